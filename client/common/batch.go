@@ -0,0 +1,161 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const (
+	// DefaultBatchMaxAmount Bets per batch used when none is configured
+	DefaultBatchMaxAmount = 10
+	// DefaultBatchMaxBytes Frame size per batch used when none is
+	// configured, chosen so packets never exceed 8kB
+	DefaultBatchMaxBytes = 8000
+)
+
+// BatchConfig Limits applied to every batch of bets
+type BatchConfig struct {
+	// MaxAmount Maximum amount of bets per batch
+	MaxAmount int
+	// MaxBytes Maximum size of the frame carrying the batch, header included
+	MaxBytes int
+}
+
+// BetSource Iterator of bets, such as a BetReader
+type BetSource interface {
+	Next() bool
+	Bet() protocol.Bet
+	Err() error
+}
+
+// positioner Bet source able to tell where its last bet was read from,
+// such as a BetReader
+type positioner interface {
+	Position() Position
+}
+
+// BatchMaker Groups the bets of a source into batches that respect both
+// the amount and the byte limits. Bets are pulled from the source only
+// when building the next batch, so at most one batch is held in memory
+//
+//	for maker.Next() {
+//		bets := maker.Batch()
+//	}
+//	if err := maker.Err(); err != nil { ... }
+type BatchMaker struct {
+	config  BatchConfig
+	source  BetSource
+	batch   []protocol.Bet
+	pending *protocol.Bet
+	// end Position of the source after the last bet of the batch. The
+	// source is ahead of it while a bet is pending, which ends at
+	// pendingEnd
+	end        Position
+	pendingEnd Position
+	err        error
+}
+
+// withDefaults Replaces zero limits by their defaults
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.MaxAmount <= 0 {
+		c.MaxAmount = DefaultBatchMaxAmount
+	}
+	if c.MaxBytes <= 0 {
+		c.MaxBytes = DefaultBatchMaxBytes
+	}
+	return c
+}
+
+// NewBatchMaker Initializes a batch maker over the given source. Zero
+// limits are replaced by their defaults
+func NewBatchMaker(config BatchConfig, source BetSource) *BatchMaker {
+	config = config.withDefaults()
+	return &BatchMaker{
+		config: config,
+		source: source,
+		batch:  make([]protocol.Bet, 0, config.MaxAmount),
+	}
+}
+
+// SetConfig Changes the limits applied from the next batch on. Zero
+// limits are replaced by their defaults
+func (m *BatchMaker) SetConfig(config BatchConfig) {
+	m.config = config.withDefaults()
+}
+
+// Next Builds the next batch. It returns false once the source is
+// exhausted or when an error arises, in which case Err reports it
+func (m *BatchMaker) Next() bool {
+	if m.err != nil {
+		return false
+	}
+
+	m.batch = m.batch[:0]
+	size := protocol.HeaderSize + protocol.BetPacketHeaderSize
+
+	for len(m.batch) < m.config.MaxAmount {
+		bet, end, ok := m.nextBet()
+		if !ok {
+			break
+		}
+
+		betSize := protocol.EncodedBetSize(bet)
+		if size+betSize > m.config.MaxBytes {
+			if len(m.batch) == 0 {
+				m.err = fmt.Errorf("bet with document %v takes %d bytes and does not fit in a batch of %d bytes",
+					bet.Document, betSize, m.config.MaxBytes)
+				return false
+			}
+			// The bet is kept to be the first one of the next batch
+			m.pending = &bet
+			m.pendingEnd = end
+			break
+		}
+
+		m.batch = append(m.batch, bet)
+		m.end = end
+		size += betSize
+	}
+
+	if m.err == nil {
+		m.err = m.source.Err()
+	}
+	return m.err == nil && len(m.batch) > 0
+}
+
+// Batch Bets of the batch built by the last successful call to Next. The
+// slice is reused between calls, so it must not be retained
+func (m *BatchMaker) Batch() []protocol.Bet {
+	return m.batch
+}
+
+// Err First error found while building batches, if any
+func (m *BatchMaker) Err() error {
+	return m.err
+}
+
+// Position Position of the source right after the last bet of the batch
+// built by the last successful call to Next. It is the zero Position if
+// the source cannot tell its position
+func (m *BatchMaker) Position() Position {
+	return m.end
+}
+
+// nextBet Pulls the next bet, which is the pending one if any, along
+// with the position of the source after it
+func (m *BatchMaker) nextBet() (protocol.Bet, Position, bool) {
+	if m.pending != nil {
+		bet := *m.pending
+		m.pending = nil
+		return bet, m.pendingEnd, true
+	}
+	if !m.source.Next() {
+		return protocol.Bet{}, Position{}, false
+	}
+	var end Position
+	if p, ok := m.source.(positioner); ok {
+		end = p.Position()
+	}
+	return m.source.Bet(), end, true
+}
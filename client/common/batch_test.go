@@ -0,0 +1,130 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// sliceSource BetSource over an in-memory list of bets
+type sliceSource struct {
+	bets []protocol.Bet
+	pos  int
+	err  error
+}
+
+func (s *sliceSource) Next() bool {
+	if s.pos >= len(s.bets) {
+		return false
+	}
+	s.pos++
+	return true
+}
+
+func (s *sliceSource) Bet() protocol.Bet { return s.bets[s.pos-1] }
+func (s *sliceSource) Err() error        { return s.err }
+
+func newTestBets(n int, name string) []protocol.Bet {
+	bets := make([]protocol.Bet, n)
+	for i := range bets {
+		bets[i] = protocol.Bet{
+			Agency:    1,
+			FirstName: name,
+			LastName:  "Lorca",
+			Document:  strconv.Itoa(30000000 + i),
+			Birthdate: time.Date(1999, 3, 17, 0, 0, 0, 0, time.UTC),
+			Number:    uint16(i),
+		}
+	}
+	return bets
+}
+
+func collectBatches(t *testing.T, m *BatchMaker) []int {
+	t.Helper()
+	var sizes []int
+	for m.Next() {
+		sizes = append(sizes, len(m.Batch()))
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return sizes
+}
+
+func TestBatchMakerHonorsMaxAmount(t *testing.T) {
+	m := NewBatchMaker(BatchConfig{MaxAmount: 4, MaxBytes: 8000}, &sliceSource{bets: newTestBets(10, "Santiago")})
+
+	if sizes := fmt.Sprint(collectBatches(t, m)); sizes != "[4 4 2]" {
+		t.Fatalf("expected batches [4 4 2], got %v", sizes)
+	}
+}
+
+func TestBatchMakerHonorsMaxBytes(t *testing.T) {
+	bets := newTestBets(10, strings.Repeat("a", 100))
+	betSize := protocol.EncodedBetSize(bets[0])
+	maxBytes := protocol.HeaderSize + protocol.BetPacketHeaderSize + 3*betSize + betSize/2
+
+	m := NewBatchMaker(BatchConfig{MaxAmount: 100, MaxBytes: maxBytes}, &sliceSource{bets: bets})
+	total := 0
+	for m.Next() {
+		if size := protocol.BetPacketSize(m.Batch()); size > maxBytes {
+			t.Fatalf("batch of %d bytes exceeds the %d bytes limit", size, maxBytes)
+		}
+		total += len(m.Batch())
+	}
+	if m.Err() != nil || total != len(bets) {
+		t.Fatalf("expected %d bets batched without errors, got %d and %v", len(bets), total, m.Err())
+	}
+}
+
+func TestBatchMakerReportsSourceErrors(t *testing.T) {
+	sourceErr := errors.New("broken source")
+	m := NewBatchMaker(BatchConfig{MaxAmount: 4}, &sliceSource{bets: newTestBets(2, "Santiago"), err: sourceErr})
+
+	if m.Next() || !errors.Is(m.Err(), sourceErr) {
+		t.Fatalf("expected source error, got %v", m.Err())
+	}
+}
+
+func TestBatchMakerSetConfigAppliesToNextBatch(t *testing.T) {
+	m := NewBatchMaker(BatchConfig{MaxAmount: 4}, &sliceSource{bets: newTestBets(10, "Santiago")})
+
+	var sizes []int
+	for m.Next() {
+		sizes = append(sizes, len(m.Batch()))
+		m.SetConfig(BatchConfig{MaxAmount: 2})
+	}
+	if fmt.Sprint(sizes) != "[4 2 2 2]" || m.Err() != nil {
+		t.Fatalf("expected batches [4 2 2 2], got %v and %v", sizes, m.Err())
+	}
+}
+
+// positionSource sliceSource that reports the index of the last bet read
+// as its position
+type positionSource struct {
+	sliceSource
+}
+
+func (s *positionSource) Position() Position {
+	return Position{Rows: uint32(s.pos)}
+}
+
+func TestBatchMakerPositionExcludesPendingBet(t *testing.T) {
+	bets := newTestBets(5, "Santiago")
+	betSize := protocol.EncodedBetSize(bets[0])
+	maxBytes := protocol.HeaderSize + protocol.BetPacketHeaderSize + 2*betSize
+	m := NewBatchMaker(BatchConfig{MaxAmount: 10, MaxBytes: maxBytes}, &positionSource{sliceSource{bets: bets}})
+
+	var ends []uint32
+	for m.Next() {
+		ends = append(ends, m.Position().Rows)
+	}
+	if fmt.Sprint(ends) != "[2 4 5]" {
+		t.Fatalf("expected batches to end after rows [2 4 5], got %v", ends)
+	}
+}
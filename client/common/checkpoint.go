@@ -0,0 +1,72 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CheckpointConfig Configuration of the upload checkpoint
+type CheckpointConfig struct {
+	// Path File the checkpoint is written to, disabled if empty
+	Path string
+	// Resume Skips the rows acknowledged according to the checkpoint
+	// left by a previous run, if any
+	Resume bool
+}
+
+// Checkpoint Progress of the upload of an agency file, written after
+// every batch acknowledged by the server so an interrupted upload can be
+// resumed without reading the file from the beginning
+type Checkpoint struct {
+	Agency string `json:"agency"`
+	// Source Agency file being uploaded, as reported by BetReader.Source
+	Source string `json:"source"`
+	// Position Position of the file after the last acknowledged row
+	Position
+	// Seq Sequence number of the last acknowledged batch
+	Seq uint32 `json:"seq"`
+}
+
+// LoadCheckpoint Reads the checkpoint at path. If there is none, an error
+// satisfying errors.Is(err, os.ErrNotExist) is returned
+func LoadCheckpoint(path string) (Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return Checkpoint{}, fmt.Errorf("could not parse checkpoint %s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// Save Writes the checkpoint to path. It is written to a temporary file
+// which then replaces the previous checkpoint, so a crash while saving
+// leaves either the old or the new one
+func (c Checkpoint) Save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
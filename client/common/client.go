@@ -1,89 +1,401 @@
 package common
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/op/go-logging"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
 )
 
-var log = logging.MustGetLogger("log")
-
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
 	ID            string
 	ServerAddress string
-	LoopAmount    int
-	LoopPeriod    time.Duration
+	Dial          DialConfig
+	// Persistent Keeps a single connection for the whole run, which is
+	// transparently reestablished if it drops
+	Persistent   bool
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	LoopPeriod   time.Duration
+	Data         DataConfig
+	Batch        BatchConfig
+	Winners      WinnersConfig
+	Checkpoint   CheckpointConfig
+}
+
+// ClientID Field identifying the client that logs the line
+func ClientID(id string) logs.Field {
+	return logs.Any("client_id", id)
 }
 
 // Client Entity that encapsulates how
 type Client struct {
 	config ClientConfig
 	conn   net.Conn
+	// unwatch Stops the goroutine that closes conn when the client
+	// context is cancelled
+	unwatch context.CancelFunc
+	// rand Source of the jitter applied to dial retries
+	rand *rand.Rand
+	// session Agency whose upload session is open in the server, to be
+	// started again if the connection is reestablished
+	session *uint8
+	// acked Bets of the agency acknowledged by the server, which is the
+	// sequence number of the last acknowledged batch
+	acked uint32
+
+	// reloadMu Guards reloadable, which is changed by Reload while the
+	// loop is running
+	reloadMu   sync.Mutex
+	reloadable ReloadableConfig
+
+	registry *metrics.Registry
+	metrics  *clientMetrics
+	status   *statusTracker
+}
+
+// ReloadableConfig Part of the client configuration that can be changed
+// while the client is running. Changes apply from the next batch on
+type ReloadableConfig struct {
+	LoopPeriod time.Duration
+	Batch      BatchConfig
 }
 
 // NewClient Initializes a new client receiving the configuration
 // as a parameter
 func NewClient(config ClientConfig) *Client {
+	registry := metrics.NewRegistry()
 	client := &Client{
-		config: config,
+		config:   config,
+		registry: registry,
+		metrics:  newClientMetrics(registry),
+		status:   newStatusTracker(),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		reloadable: ReloadableConfig{
+			LoopPeriod: config.LoopPeriod,
+			Batch:      config.Batch,
+		},
 	}
 	return client
 }
 
-// CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+// Reload Replaces the reloadable configuration of the client. It is safe
+// to call while the client loop is running
+func (c *Client) Reload(config ReloadableConfig) {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	c.reloadable = config
+}
+
+// Metrics Registry of the metrics updated by the client, to be exposed
+// to Prometheus
+func (c *Client) Metrics() *metrics.Registry {
+	return c.registry
+}
+
+// currentConfig Reloadable configuration in use
+func (c *Client) currentConfig() ReloadableConfig {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+	return c.reloadable
+}
+
+// StartClientLoop Uploads the bets of the agency file to the server in a
+// single session: a start packet, one packet per batch of bets, each one
+// acknowledged by the server, and a finish packet. Once the session is
+// finished, the winners of the agency are queried. Cancelling ctx aborts
+// the loop and releases every resource in use
+func (c *Client) StartClientLoop(ctx context.Context) {
+	defer c.closeClientSocket()
+	defer func() {
+		if ctx.Err() != nil {
+			logs.Info("shutdown", logs.Success, ClientID(c.config.ID))
+		}
+	}()
+
+	agency, err := strconv.ParseUint(c.config.ID, 10, 8)
+	if err != nil {
+		err = fmt.Errorf("invalid agency id: %w", err)
+		c.status.fail(err)
+		logs.Critical("start_session", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		return
+	}
+
+	reader, err := OpenBetReader(c.config.Data, c.config.ID)
+	if err != nil {
+		c.status.fail(err)
+		logs.Critical("open_bets", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		return
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			logs.Error("close_bets", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+			return
+		}
+		logs.Info("close_bets", logs.Success, ClientID(c.config.ID))
+	}()
+	logs.Info("open_bets", logs.Success, ClientID(c.config.ID), logs.Any("source", reader.Source()))
+
+	if c.config.Checkpoint.Resume {
+		if err := c.resumeFromCheckpoint(reader); err != nil {
+			c.status.fail(err)
+			logs.Critical("resume_upload", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+			return
+		}
+	}
+
+	c.status.setPhase(PhaseUploading)
+	if err := c.uploadBets(ctx, uint8(agency), reader); err != nil {
+		c.status.fail(err)
+		return
+	}
+
+	c.status.setPhase(PhaseWaitingWinners)
+	winners, err := c.queryWinners(ctx, uint8(agency))
+	if err != nil {
+		c.status.fail(err)
+		c.logActionError("consulta_ganadores", err)
+		return
+	}
+	c.status.done(len(winners))
+	logs.Info("consulta_ganadores", logs.Success, logs.Any("cant_ganadores", len(winners)))
+}
+
+// uploadBets Runs the upload session of the agency over a single
+// connection, which is closed once the session is finished. Failures
+// are logged before being returned
+func (c *Client) uploadBets(ctx context.Context, agency uint8, reader *BetReader) error {
+	if err := c.acquireConnection(ctx); err != nil {
+		return err
+	}
+	defer c.releaseConnection()
+
+	stored, err := c.sendBetStart(ctx, agency)
 	if err != nil {
-		log.Criticalf(
-			"action: connect | result: fail | client_id: %v | error: %v",
-			c.config.ID,
-			err,
+		c.logActionError("start_session", err)
+		return err
+	}
+	if err := c.skipStoredBets(reader, stored); err != nil {
+		logs.Error("resume_upload", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		return err
+	}
+
+	sent, err := c.sendBatches(ctx, NewBatchMaker(c.currentConfig().Batch, reader), reader.Source())
+	if err != nil {
+		c.logActionError("apuesta_enviada", err)
+		return err
+	}
+
+	if err := c.sendBetFinish(ctx, agency); err != nil {
+		c.logActionError("finish_session", err)
+		return err
+	}
+	logs.Info("loop_finished", logs.Success, ClientID(c.config.ID), logs.Any("cantidad", sent))
+	return nil
+}
+
+// logActionError Logs the failure of an action. Actions aborted because
+// the client context was cancelled are part of a graceful shutdown, so
+// they are logged as interrupted instead
+func (c *Client) logActionError(action string, err error) {
+	if errors.Is(err, context.Canceled) {
+		c.logInterrupted(action)
+		return
+	}
+	logs.Error(action, logs.Fail, ClientID(c.config.ID), logs.Err(err))
+}
+
+// logInterrupted Logs an action aborted by the shutdown of the client
+func (c *Client) logInterrupted(action string) {
+	logs.Info(action, logs.Interrupted, ClientID(c.config.ID))
+}
+
+// sendBetStart Starts the upload session of the agency. The amount of
+// bets of the agency the server already stored is returned
+func (c *Client) sendBetStart(ctx context.Context, agency uint8) (uint32, error) {
+	reply, err := c.request(ctx, &protocol.BetStartPacket{AgencyID: agency})
+	if err != nil {
+		return 0, err
+	}
+	c.session = &agency
+	logs.Info("start_session", logs.Success, ClientID(c.config.ID))
+	return reply.Count, nil
+}
+
+// resumeFromCheckpoint Moves the reader past the rows acknowledged in a
+// previous run, according to the checkpoint it left. Checkpoints of other
+// agencies or files are ignored
+func (c *Client) resumeFromCheckpoint(reader *BetReader) error {
+	checkpoint, err := LoadCheckpoint(c.config.Checkpoint.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		logs.Info("resume_upload", logs.Ignored, ClientID(c.config.ID), logs.Err(errors.New("no checkpoint found")))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if checkpoint.Agency != c.config.ID || checkpoint.Source != reader.Source() {
+		logs.Warning("resume_upload", logs.Ignored,
+			ClientID(c.config.ID),
+			logs.Err(fmt.Errorf("checkpoint belongs to %s of agency %s", checkpoint.Source, checkpoint.Agency)),
 		)
+		return nil
 	}
-	c.conn = conn
+
+	if err := reader.Seek(checkpoint.Position); err != nil {
+		return fmt.Errorf("could not resume from checkpoint: %w", err)
+	}
+	c.acked = checkpoint.Seq
+	logs.Info("resume_upload", logs.Success, ClientID(c.config.ID), logs.Any("rows", checkpoint.Rows), logs.Any("seq", checkpoint.Seq))
 	return nil
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
-	// There is an autoincremental msgID to identify every message sent
-	// Messages if the message amount threshold has not been surpassed
-	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
-
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
-			c.config.ID,
-			msgID,
+// saveCheckpoint Records the progress of the upload after an acknowledged
+// batch. Failures are only logged, since the server tells which bets it
+// already stored anyway
+func (c *Client) saveCheckpoint(checkpoint Checkpoint) {
+	if c.config.Checkpoint.Path == "" {
+		return
+	}
+	if err := checkpoint.Save(c.config.Checkpoint.Path); err != nil {
+		logs.Warning("save_checkpoint", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+	}
+}
+
+// skipStoredBets Skips the bets of the source the server already stored
+// beyond the ones acknowledged so far, so an upload interrupted in a
+// previous run resumes after the last batch stored. Numbering of the
+// following batches starts after them
+func (c *Client) skipStoredBets(source BetSource, stored uint32) error {
+	if stored < c.acked {
+		return fmt.Errorf("the server stored %d bets of the agency, fewer than the %d acknowledged according to the checkpoint", stored, c.acked)
+	}
+	if stored == c.acked {
+		return nil
+	}
+
+	skipped := uint32(0)
+	for c.acked+skipped < stored && source.Next() {
+		skipped++
+	}
+	if err := source.Err(); err != nil {
+		return fmt.Errorf("could not read bets: %w", err)
+	}
+	read := c.acked + skipped
+	c.acked = stored
+	if read < stored {
+		logs.Warning("resume_upload", logs.Success,
+			ClientID(c.config.ID),
+			logs.Any("skipped", skipped),
+			logs.Err(fmt.Errorf("the server stored %d bets of the agency, more than the %d read", stored, read)),
 		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		c.conn.Close()
+		return nil
+	}
+	logs.Info("resume_upload", logs.Success, ClientID(c.config.ID), logs.Any("skipped", skipped))
+	return nil
+}
+
+// sendBatches Sends every batch built by the batch maker, waiting for the
+// server to acknowledge each one before sending the next. Batches are
+// numbered after the bets already acknowledged, so if one is sent again
+// after a reconnection the server does not store it twice, and the
+// checkpoint of the source is saved after each one. The reloadable
+// configuration is read again before every batch. The amount of bets
+// stored by the server is returned
+func (c *Client) sendBatches(ctx context.Context, batches *BatchMaker, source string) (int, error) {
+	sent := 0
+	for {
+		current := c.currentConfig()
+		batches.SetConfig(current.Batch)
+		if !batches.Next() {
+			break
+		}
 
+		bets := batches.Batch()
+		seq := c.acked + uint32(len(bets))
+		reply, err := c.request(ctx, &protocol.BetPacket{Seq: seq, Bets: bets})
 		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
-				c.config.ID,
-				err,
-			)
-			return
+			var rejection *protocol.ErrorPacket
+			if errors.As(err, &rejection) {
+				c.metrics.batchesRejected.Inc()
+			}
+			return sent, err
+		}
+		if int(reply.Count) != len(bets) {
+			c.metrics.batchesRejected.Inc()
+			return sent, fmt.Errorf("server stored %d bets out of a batch of %d", reply.Count, len(bets))
 		}
+		c.acked = seq
+		c.saveCheckpoint(Checkpoint{Agency: c.config.ID, Source: source, Position: batches.Position(), Seq: seq})
+		c.metrics.batchesAcked.Inc()
+		c.metrics.betsSent.Add(uint64(len(bets)))
+		c.status.batchSent(len(bets))
 
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
-			c.config.ID,
-			msg,
-		)
+		sent += len(bets)
+		logs.Info("apuesta_enviada", logs.Success, ClientID(c.config.ID), logs.Any("cantidad", reply.Count))
+
+		// Wait a time between sending one batch and the next one
+		if err := sleep(ctx, current.LoopPeriod); err != nil {
+			return sent, err
+		}
+	}
+	if err := batches.Err(); err != nil {
+		return sent, fmt.Errorf("could not read bets: %w", err)
+	}
+	return sent, nil
+}
 
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+// sendBetFinish Finishes the upload session of the agency
+func (c *Client) sendBetFinish(ctx context.Context, agency uint8) error {
+	if _, err := c.request(ctx, &protocol.BetFinishPacket{AgencyID: agency}); err != nil {
+		return err
+	}
+	c.session = nil
+	logs.Info("finish_session", logs.Success, ClientID(c.config.ID))
+	return nil
+}
 
+// request Sends a packet and expects a successful reply from the server.
+// Error packets are returned as errors that can be checked with
+// errors.Is against the protocol error values
+func (c *Client) request(ctx context.Context, request protocol.Packet) (*protocol.ReplyPacket, error) {
+	reply, err := c.exchange(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p := reply.(type) {
+	case *protocol.ReplyPacket:
+		return p, nil
+	case *protocol.ErrorPacket:
+		return nil, fmt.Errorf("%v packet rejected: %w", request.Type(), p)
+	default:
+		return nil, fmt.Errorf("unexpected %v packet in response to %v packet", reply.Type(), request.Type())
+	}
+}
+
+// sleep Waits for the given duration unless ctx is cancelled first, in
+// which case the context error is returned
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
 }
@@ -0,0 +1,444 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// fakeServer Minimal lottery server that acknowledges every packet and
+// records the packets it receives
+type fakeServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received []protocol.MessageType
+	conns    int
+	// dropAfter Closes the connection without replying to the n-th bet
+	// packet received, if greater than zero
+	dropAfter int
+	bets      int
+	// notDone Replies that the lottery is not done to the first notDone
+	// winners queries
+	notDone        int
+	winnersQueries int
+	// stall Never replies, holding every request in flight
+	stall bool
+	// stored Bets of the agency reported as already stored when a session
+	// is started
+	stored uint32
+	// seqs Sequence numbers of the bet packets received
+	seqs []uint32
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	s := &fakeServer{listener: ln}
+	go s.serve()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := protocol.Recv(conn)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.received = append(s.received, packet.Type())
+		if s.stall {
+			s.mu.Unlock()
+			continue
+		}
+		var reply protocol.Packet = &protocol.ReplyPacket{Message: "OK"}
+		switch p := packet.(type) {
+		case *protocol.BetStartPacket:
+			reply = &protocol.ReplyPacket{Count: s.stored, Message: "OK"}
+		case *protocol.BetPacket:
+			s.seqs = append(s.seqs, p.Seq)
+			s.bets++
+			if s.bets == s.dropAfter {
+				s.mu.Unlock()
+				return
+			}
+			reply = &protocol.ReplyPacket{Count: uint32(len(p.Bets)), Message: "STORED"}
+		case *protocol.GetWinnersPacket:
+			s.winnersQueries++
+			if s.winnersQueries <= s.notDone {
+				reply = &protocol.ErrorPacket{Code: protocol.CodeLotteryNotDone, Message: "lottery not done"}
+			} else {
+				reply = &protocol.ReplyWinnersPacket{AgencyID: p.AgencyID, Winners: []string{"30904465"}}
+			}
+		}
+		s.mu.Unlock()
+
+		if err := protocol.Send(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) stats() ([]protocol.MessageType, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]protocol.MessageType(nil), s.received...), s.conns
+}
+
+func newTestClient(t *testing.T, address string, persistent bool) *Client {
+	t.Helper()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "agency-1.csv"), agencyRows)
+	return NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Persistent:    persistent,
+		Dial:          DialConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+		Data:          DataConfig{Dir: dir},
+		Batch:         BatchConfig{MaxAmount: 1},
+		Winners:       WinnersConfig{Mode: WinnersModePoll, Cooldown: time.Millisecond, Timeout: time.Second},
+	})
+}
+
+func TestPersistentClientUsesASingleConnection(t *testing.T) {
+	server := newFakeServer(t)
+	newTestClient(t, server.listener.Addr().String(), true).StartClientLoop(context.Background())
+
+	received, conns := server.stats()
+	if conns != 1 {
+		t.Fatalf("expected 1 connection, got %d", conns)
+	}
+	if len(received) != 5 || received[4] != protocol.MsgGetWinners {
+		t.Fatalf("expected start, 2 batches, finish and winners query, got %v", received)
+	}
+}
+
+func TestPersistentClientResendsAfterConnectionDrop(t *testing.T) {
+	server := newFakeServer(t)
+	server.dropAfter = 2
+	newTestClient(t, server.listener.Addr().String(), true).StartClientLoop(context.Background())
+
+	received, conns := server.stats()
+	if conns != 2 {
+		t.Fatalf("expected 2 connections, got %d", conns)
+	}
+	expected := []protocol.MessageType{
+		protocol.MsgBetStart, protocol.MsgBet, protocol.MsgBet,
+		protocol.MsgBetStart, protocol.MsgBet, protocol.MsgBetFinish, protocol.MsgGetWinners,
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, received)
+		}
+	}
+	if server.seqs[1] != 2 || server.seqs[2] != 2 {
+		t.Fatalf("expected the resent batch to keep its sequence number, got %v", server.seqs)
+	}
+}
+
+func TestClientResumesAfterTheBetsTheServerStored(t *testing.T) {
+	server := newFakeServer(t)
+	server.stored = 1
+	client := newTestClient(t, server.listener.Addr().String(), true)
+	client.StartClientLoop(context.Background())
+
+	if len(server.seqs) != 1 || server.seqs[0] != 2 {
+		t.Fatalf("expected only the second bet to be sent, got sequence numbers %v", server.seqs)
+	}
+	if status := client.Status(); status.Phase != PhaseDone || status.BetsSent != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+// captureLogs Redirects the log lines to the returned buffer until the
+// test finishes
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := logs.Init(&buf, "INFO", logs.FormatText); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logs.Init(os.Stderr, "INFO", logs.FormatText) })
+	return &buf
+}
+
+// runCancelled Runs the client loop, cancelling it once the server
+// received the given amount of packets, and returns the log lines written
+// in the meantime. The test fails if the loop does not return promptly
+func runCancelled(t *testing.T, client *Client, server *fakeServer, packets int) string {
+	t.Helper()
+	logs := captureLogs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		client.StartClientLoop(ctx)
+	}()
+	for {
+		if received, _ := server.stats(); len(received) >= packets {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the client loop to return promptly once cancelled")
+	}
+	return logs.String()
+}
+
+func TestClientCancelsInFlightRequest(t *testing.T) {
+	server := newFakeServer(t)
+	server.stall = true
+	client := newTestClient(t, server.listener.Addr().String(), false)
+	output := runCancelled(t, client, server, 1)
+
+	if strings.Contains(output, "result: fail") {
+		t.Fatalf("expected no failures on cancellation, got:\n%s", output)
+	}
+	if !strings.Contains(output, "action: start_session | result: interrupted") {
+		t.Fatalf("expected the session start to be interrupted, got:\n%s", output)
+	}
+	if status := client.Status(); status.Phase == PhaseFailed {
+		t.Fatalf("expected the cancellation not to fail the run, got %+v", status)
+	}
+}
+
+func TestClientCancelsSleepBetweenBatches(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server.listener.Addr().String(), false)
+	client.Reload(ReloadableConfig{LoopPeriod: time.Hour, Batch: client.currentConfig().Batch})
+	// Start and first batch
+	output := runCancelled(t, client, server, 2)
+
+	if strings.Contains(output, "result: fail") {
+		t.Fatalf("expected no failures on cancellation, got:\n%s", output)
+	}
+	if !strings.Contains(output, "action: apuesta_enviada | result: interrupted") {
+		t.Fatalf("expected the upload to be interrupted, got:\n%s", output)
+	}
+	if status := client.Status(); status.Phase == PhaseFailed {
+		t.Fatalf("expected the cancellation not to fail the run, got %+v", status)
+	}
+}
+
+func newWinnersClient(address string, mode WinnersMode, cooldown, timeout time.Duration) *Client {
+	return NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Winners:       WinnersConfig{Mode: mode, Cooldown: cooldown, Timeout: timeout},
+	})
+}
+
+func TestClientRetriesWinnersWhileLotteryIsNotDone(t *testing.T) {
+	for _, mode := range []WinnersMode{WinnersModePoll, WinnersModeWait} {
+		server := newFakeServer(t)
+		server.notDone = 3
+		client := newWinnersClient(server.listener.Addr().String(), mode, time.Millisecond, time.Second)
+
+		winners, err := client.queryWinners(context.Background(), 1)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", mode, err)
+		}
+		if len(winners) != 1 || winners[0] != "30904465" {
+			t.Fatalf("%s: unexpected winners %v", mode, winners)
+		}
+
+		received, conns := server.stats()
+		if len(received) != 4 {
+			t.Fatalf("%s: expected 3 retries after the first query, got %d queries", mode, len(received))
+		}
+		expectedConns := 4
+		if mode == WinnersModeWait {
+			expectedConns = 1
+		}
+		if conns != expectedConns {
+			t.Fatalf("%s: expected %d connections, got %d", mode, expectedConns, conns)
+		}
+	}
+}
+
+func TestClientGivesUpOnWinnersAfterTimeout(t *testing.T) {
+	server := newFakeServer(t)
+	server.notDone = 1000
+	// Queries are spaced so the last one is answered well before the
+	// timeout expires
+	client := newWinnersClient(server.listener.Addr().String(), WinnersModePoll, 40*time.Millisecond, 150*time.Millisecond)
+
+	start := time.Now()
+	_, err := client.queryWinners(context.Background(), 1)
+	if !errors.Is(err, protocol.ErrLotteryNotDone) {
+		t.Fatalf("expected the lottery not done error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up after the timeout, took %v", elapsed)
+	}
+	if received, _ := server.stats(); len(received) < 2 || len(received) > 4 {
+		t.Fatalf("expected a query every cooldown until the timeout, got %d queries", len(received))
+	}
+}
+
+func TestStalledServerReportsReadTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept the connection but never reply
+		if conn, err := ln.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	c := NewClient(ClientConfig{ID: "1", ServerAddress: ln.Addr().String(), ReadTimeout: 20 * time.Millisecond})
+	if err := c.createClientSocket(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.closeClientSocket()
+
+	_, err = c.exchange(context.Background(), &protocol.BetStartPacket{AgencyID: 1})
+	if !errors.Is(err, ErrReadTimeout) {
+		t.Fatalf("expected a read timeout, got %v", err)
+	}
+}
+
+func TestClientMetricsCountTraffic(t *testing.T) {
+	server := newFakeServer(t)
+	server.dropAfter = 2
+	client := newTestClient(t, server.listener.Addr().String(), true)
+	client.StartClientLoop(context.Background())
+
+	m := client.metrics
+	if m.batchesAcked.Value() != 2 || m.betsSent.Value() != 2 || m.batchesRejected.Value() != 0 {
+		t.Fatalf("expected 2 batches and bets acked, got %d and %d", m.batchesAcked.Value(), m.betsSent.Value())
+	}
+	if m.reconnects.Value() != 1 || m.messagesSent.WithLabel("BET_START").Value() != 2 {
+		t.Fatalf("expected 1 reconnect and 2 session starts, got %d and %d",
+			m.reconnects.Value(), m.messagesSent.WithLabel("BET_START").Value())
+	}
+	if m.bytesWritten.Value() == 0 || m.dialDuration.Count() != 2 || m.roundTripDuration.WithLabel("BET").Count() != 2 {
+		t.Fatalf("expected bytes written, 2 dials and 2 bet round trips, got %d, %d and %d",
+			m.bytesWritten.Value(), m.dialDuration.Count(), m.roundTripDuration.WithLabel("BET").Count())
+	}
+}
+
+func TestClientStatusTracksProgress(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server.listener.Addr().String(), false)
+	if status := client.Status(); status.Phase != PhaseStarting || status.Ready || !status.Healthy {
+		t.Fatalf("unexpected initial status %+v", status)
+	}
+
+	client.StartClientLoop(context.Background())
+
+	status := client.Status()
+	if status.Phase != PhaseDone || !status.Ready || !status.Healthy || status.BetsSent != 2 || status.BatchesSent != 2 {
+		t.Fatalf("unexpected final status %+v", status)
+	}
+	if status.Winners == nil || *status.Winners != 1 {
+		t.Fatalf("expected 1 winner, got %v", status.Winners)
+	}
+}
+
+func TestClientStatusIsUnhealthyOnceReconnectBudgetIsExhausted(t *testing.T) {
+	server := newFakeServer(t)
+	address := server.listener.Addr().String()
+	server.listener.Close()
+
+	client := newTestClient(t, address, false)
+	client.StartClientLoop(context.Background())
+
+	status := client.Status()
+	if status.Phase != PhaseFailed || status.Ready || status.Healthy || status.LastError == "" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestClientSavesCheckpointAfterEveryBatch(t *testing.T) {
+	server := newFakeServer(t)
+	client := newTestClient(t, server.listener.Addr().String(), false)
+	client.config.Checkpoint.Path = filepath.Join(t.TempDir(), "checkpoint.json")
+	client.StartClientLoop(context.Background())
+
+	checkpoint, err := LoadCheckpoint(client.config.Checkpoint.Path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := Position{Offset: int64(len(agencyRows)), Line: 2, Rows: 2}
+	if checkpoint.Agency != "1" || checkpoint.Position != expected || checkpoint.Seq != 2 {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+}
+
+func TestClientResumesFromCheckpoint(t *testing.T) {
+	for _, stored := range []uint32{1, 0} {
+		server := newFakeServer(t)
+		server.stored = stored
+		client := newTestClient(t, server.listener.Addr().String(), false)
+		client.config.Checkpoint = CheckpointConfig{Path: filepath.Join(t.TempDir(), "checkpoint.json"), Resume: true}
+
+		// Checkpoint left after the first row was acknowledged
+		reader, err := OpenBetReader(client.config.Data, "1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.Next()
+		checkpoint := Checkpoint{Agency: "1", Source: reader.Source(), Position: reader.Position(), Seq: 1}
+		reader.Close()
+		if err := checkpoint.Save(client.config.Checkpoint.Path); err != nil {
+			t.Fatal(err)
+		}
+
+		client.StartClientLoop(context.Background())
+		status := client.Status()
+		if stored == 0 {
+			// The server does not have the bets the checkpoint says were
+			// acknowledged
+			if status.Phase != PhaseFailed {
+				t.Fatalf("expected the upload to fail, got %+v", status)
+			}
+			continue
+		}
+		if len(server.seqs) != 1 || server.seqs[0] != 2 || status.Phase != PhaseDone {
+			t.Fatalf("expected only the second bet to be sent, got sequence numbers %v and status %+v", server.seqs, status)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const (
+	// DefaultReadTimeout Time allowed to receive a reply from the server
+	DefaultReadTimeout = 30 * time.Second
+	// DefaultWriteTimeout Time allowed to send a packet to the server
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+var (
+	// ErrConnectTimeout A dial attempt did not complete within the
+	// configured connect timeout
+	ErrConnectTimeout = errors.New("connect timeout")
+	// ErrWriteTimeout A packet could not be sent within the configured
+	// write timeout
+	ErrWriteTimeout = errors.New("write timeout")
+	// ErrReadTimeout The reply was not received within the configured
+	// read timeout
+	ErrReadTimeout = errors.New("read timeout")
+)
+
+// isTimeout Reports whether err was caused by an expired deadline
+func isTimeout(err error) bool {
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// timeoutError Replaces deadline errors by the given timeout error value,
+// keeping the original message, so callers can tell them apart with
+// errors.Is. Other errors are returned unchanged
+func timeoutError(err, timeoutErr error, timeout time.Duration) error {
+	if !isTimeout(err) {
+		return err
+	}
+	return fmt.Errorf("%w after %v: %v", timeoutErr, timeout, err)
+}
+
+// CreateClientSocket Initializes client socket, retrying failed
+// attempts as configured. In case of failure, error is printed in
+// stdout/stderr and returned, leaving the client without connection.
+// The dial is aborted if ctx is cancelled, and so is any read or write
+// on the connection once it is established
+func (c *Client) createClientSocket(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.logInterrupted("connect")
+		} else {
+			c.status.unreachable(err)
+			logs.Critical("connect", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		}
+		return err
+	}
+	c.status.connected()
+	c.conn = conn
+	c.unwatch = watchConnection(ctx, conn)
+	return nil
+}
+
+// watchConnection Closes conn as soon as ctx is cancelled, unblocking any
+// read or write in progress. The returned function stops the watch
+func watchConnection(ctx context.Context, conn net.Conn) context.CancelFunc {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+// closeClientSocket Releases the current connection, if any
+func (c *Client) closeClientSocket() {
+	if c.conn == nil {
+		return
+	}
+	c.unwatch()
+	if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		logs.Error("close_connection", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+	} else {
+		logs.Info("close_connection", logs.Success, ClientID(c.config.ID))
+	}
+	c.conn = nil
+}
+
+// acquireConnection Makes sure there is a connection for the next
+// exchanges. In persistent mode the connection of previous exchanges is
+// reused, otherwise a new one is created
+func (c *Client) acquireConnection(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+	return c.createClientSocket(ctx)
+}
+
+// releaseConnection Closes the connection once the exchanges it was
+// acquired for are done, unless running in persistent mode
+func (c *Client) releaseConnection() {
+	if !c.config.Persistent {
+		c.closeClientSocket()
+	}
+}
+
+// reconnect Replaces a dropped connection by a new one. If an upload
+// session was open, it is started again in the new connection before
+// resuming the exchanges
+func (c *Client) reconnect(ctx context.Context) error {
+	c.closeClientSocket()
+	if err := c.createClientSocket(ctx); err != nil {
+		return err
+	}
+	c.metrics.reconnects.Inc()
+	logs.Info("reconnect", logs.Success, ClientID(c.config.ID))
+
+	if c.session == nil {
+		return nil
+	}
+	reply, err := c.roundTrip(ctx, &protocol.BetStartPacket{AgencyID: *c.session}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("could not restart session: %w", err)
+	}
+	if p, ok := reply.(*protocol.ErrorPacket); ok {
+		return fmt.Errorf("could not restart session: %w", p)
+	}
+	return nil
+}
+
+// exchange Sends a packet through the current connection and waits for
+// the packet the server answers with. If ctx was cancelled in the
+// meantime, its error is returned instead of the I/O one
+func (c *Client) exchange(ctx context.Context, request protocol.Packet) (protocol.Packet, error) {
+	return c.exchangeUntil(ctx, request, time.Time{})
+}
+
+// exchangeUntil Same as exchange, but the reply is awaited until the
+// given deadline instead of the configured read timeout. In persistent
+// mode, if the connection drops the client reconnects and sends the
+// request again once
+func (c *Client) exchangeUntil(ctx context.Context, request protocol.Packet, deadline time.Time) (protocol.Packet, error) {
+	reply, err := c.roundTrip(ctx, request, deadline)
+	if err == nil || ctx.Err() != nil || !c.config.Persistent {
+		return reply, err
+	}
+
+	logs.Warning("reconnect", logs.InProgress, ClientID(c.config.ID), logs.Err(err))
+	if err := c.reconnect(ctx); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("could not resend %v packet: %w", request.Type(), err)
+	}
+	return c.roundTrip(ctx, request, deadline)
+}
+
+// roundTrip Writes the request and reads its reply, each one bounded by
+// its own deadline. Failures are logged as send_message or
+// receive_message failures, with expired deadlines reported as
+// ErrWriteTimeout and ErrReadTimeout respectively
+func (c *Client) roundTrip(ctx context.Context, request protocol.Packet, deadline time.Time) (protocol.Packet, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("could not send %v packet: not connected", request.Type())
+	}
+
+	start := time.Now()
+	writeTimeout := c.writeTimeout()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return nil, fmt.Errorf("could not set write deadline: %w", err)
+	}
+	if err := protocol.Send(countingWriter{w: c.conn, counter: c.metrics.bytesWritten}, request); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		err = fmt.Errorf("could not send %v packet: %w", request.Type(), timeoutError(err, ErrWriteTimeout, writeTimeout))
+		logs.Error("send_message", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		return nil, err
+	}
+	c.metrics.messagesSent.WithLabel(request.Type().String()).Inc()
+
+	if deadline.IsZero() {
+		deadline = time.Now().Add(c.readTimeout())
+	}
+	readTimeout := time.Until(deadline).Round(time.Millisecond)
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("could not set read deadline: %w", err)
+	}
+	reply, err := protocol.Recv(c.conn)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		err = fmt.Errorf("could not receive reply to %v packet: %w", request.Type(), timeoutError(err, ErrReadTimeout, readTimeout))
+		logs.Error("receive_message", logs.Fail, ClientID(c.config.ID), logs.Err(err))
+		return nil, err
+	}
+	c.metrics.roundTripDuration.WithLabel(request.Type().String()).Since(start)
+	return reply, nil
+}
+
+func (c *Client) readTimeout() time.Duration {
+	if c.config.ReadTimeout <= 0 {
+		return DefaultReadTimeout
+	}
+	return c.config.ReadTimeout
+}
+
+func (c *Client) writeTimeout() time.Duration {
+	if c.config.WriteTimeout <= 0 {
+		return DefaultWriteTimeout
+	}
+	return c.config.WriteTimeout
+}
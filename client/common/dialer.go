@@ -0,0 +1,107 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+)
+
+const (
+	// DefaultConnectTimeout Time allowed for a single dial attempt
+	DefaultConnectTimeout = 5 * time.Second
+	// DefaultDialMaxAttempts Dial attempts made before giving up
+	DefaultDialMaxAttempts = 5
+	// DefaultDialInitialBackoff Wait before the second dial attempt
+	DefaultDialInitialBackoff = 500 * time.Millisecond
+	// DefaultDialMaxBackoff Upper bound for the wait between attempts
+	DefaultDialMaxBackoff = 10 * time.Second
+)
+
+// DialConfig Configuration of the connection attempts to the server
+type DialConfig struct {
+	// Timeout Time allowed for a single dial attempt
+	Timeout time.Duration
+	// MaxAttempts Dial attempts made before giving up
+	MaxAttempts int
+	// InitialBackoff Wait before the second attempt. Every following
+	// wait doubles the previous one, up to MaxBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff Upper bound for the wait between attempts
+	MaxBackoff time.Duration
+	// KeepAlive Period of the TCP keep-alive probes. Zero uses the
+	// system default and a negative value disables them
+	KeepAlive time.Duration
+}
+
+// withDefaults Replaces zero values by their defaults
+func (d DialConfig) withDefaults() DialConfig {
+	if d.Timeout <= 0 {
+		d.Timeout = DefaultConnectTimeout
+	}
+	if d.MaxAttempts <= 0 {
+		d.MaxAttempts = DefaultDialMaxAttempts
+	}
+	if d.InitialBackoff <= 0 {
+		d.InitialBackoff = DefaultDialInitialBackoff
+	}
+	if d.MaxBackoff <= 0 {
+		d.MaxBackoff = DefaultDialMaxBackoff
+	}
+	return d
+}
+
+// backoff Wait before the given retry (1 for the first one). The wait
+// grows exponentially and half of it is randomized, so clients started
+// at the same time do not retry in lockstep
+func (d DialConfig) backoff(retry int, rnd *rand.Rand) time.Duration {
+	wait := d.InitialBackoff
+	for i := 1; i < retry && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > d.MaxBackoff {
+		wait = d.MaxBackoff
+	}
+	half := wait / 2
+	return half + time.Duration(rnd.Int63n(int64(half)+1))
+}
+
+// dial Connects to the server, retrying failed attempts with exponential
+// backoff until the max attempts are exhausted or ctx is cancelled. The
+// error of the last attempt is returned if every attempt fails
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	config := c.config.Dial.withDefaults()
+	dialer := net.Dialer{Timeout: config.Timeout, KeepAlive: config.KeepAlive}
+
+	var lastErr error
+	for attempt := 1; attempt <= config.MaxAttempts; attempt++ {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", c.config.ServerAddress)
+		c.metrics.dialDuration.Since(start)
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = timeoutError(err, ErrConnectTimeout, config.Timeout)
+		if attempt == config.MaxAttempts {
+			break
+		}
+
+		wait := config.backoff(attempt, c.rand)
+		logs.Warning("connect", logs.Retry,
+			ClientID(c.config.ID),
+			logs.Any("attempt", attempt),
+			logs.Any("retry_in", wait),
+			logs.Err(lastErr),
+		)
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("could not connect to %v after %d attempts: %w", c.config.ServerAddress, config.MaxAttempts, lastErr)
+}
@@ -0,0 +1,72 @@
+package common
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsUpToMaxWithJitter(t *testing.T) {
+	config := DialConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	rnd := rand.New(rand.NewSource(1))
+
+	cases := map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: time.Second}
+	for retry, expected := range cases {
+		for i := 0; i < 50; i++ {
+			if wait := config.backoff(retry, rnd); wait < expected/2 || wait > expected {
+				t.Fatalf("retry %d: expected a wait between %v and %v, got %v", retry, expected/2, expected, wait)
+			}
+		}
+	}
+}
+
+func TestDialRetriesUntilServerIsUp(t *testing.T) {
+	// Reserve a free port and release it, so the first attempts fail
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		if ln, err := net.Listen("tcp", address); err == nil {
+			defer ln.Close()
+			if conn, err := ln.Accept(); err == nil {
+				conn.Close()
+			}
+		}
+	}()
+
+	c := NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Dial:          DialConfig{MaxAttempts: 20, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond},
+	})
+	conn, err := c.dial(context.Background())
+	if err != nil {
+		t.Fatalf("expected to connect after retrying, got %v", err)
+	}
+	conn.Close()
+}
+
+func TestDialFailsAfterMaxAttempts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	c := NewClient(ClientConfig{
+		ID:            "1",
+		ServerAddress: address,
+		Dial:          DialConfig{MaxAttempts: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	})
+	if err := c.createClientSocket(context.Background()); err == nil || c.conn != nil {
+		t.Fatalf("expected an error and no connection, got %v and %v", err, c.conn)
+	}
+}
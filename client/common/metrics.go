@@ -0,0 +1,44 @@
+package common
+
+import (
+	"io"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/metrics"
+)
+
+// clientMetrics Metrics updated by the client while talking to the server
+type clientMetrics struct {
+	messagesSent      *metrics.CounterVec
+	betsSent          *metrics.Counter
+	batchesAcked      *metrics.Counter
+	batchesRejected   *metrics.Counter
+	bytesWritten      *metrics.Counter
+	reconnects        *metrics.Counter
+	dialDuration      *metrics.Histogram
+	roundTripDuration *metrics.HistogramVec
+}
+
+func newClientMetrics(r *metrics.Registry) *clientMetrics {
+	return &clientMetrics{
+		messagesSent:      r.NewCounterVec("client_messages_sent_total", "Packets sent to the server by message type.", "type"),
+		betsSent:          r.NewCounter("client_bets_sent_total", "Bets sent in batches acknowledged by the server."),
+		batchesAcked:      r.NewCounter("client_batches_acked_total", "Batches of bets acknowledged by the server."),
+		batchesRejected:   r.NewCounter("client_batches_rejected_total", "Batches of bets rejected by the server."),
+		bytesWritten:      r.NewCounter("client_bytes_written_total", "Bytes written to the server connection."),
+		reconnects:        r.NewCounter("client_reconnects_total", "Connections reestablished after a drop."),
+		dialDuration:      r.NewHistogram("client_dial_duration_seconds", "Duration of every dial attempt.", metrics.DefaultBuckets),
+		roundTripDuration: r.NewHistogramVec("client_round_trip_duration_seconds", "Time from sending a packet until its reply is received, by message type.", "type", metrics.DefaultBuckets),
+	}
+}
+
+// countingWriter Writer that adds every byte written to a counter
+type countingWriter struct {
+	w       io.Writer
+	counter *metrics.Counter
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.counter.Add(uint64(n))
+	return n, err
+}
@@ -0,0 +1,268 @@
+package common
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// betFields Amount of columns of every agency file row:
+// first name, last name, document, birthdate and number
+const betFields = 5
+
+// DataConfig Location of the agency bet files
+type DataConfig struct {
+	// Dir Directory where agency-{ID}.csv files are mounted
+	Dir string
+	// Archive Zip file holding agency-{ID}.csv files. Used when the file
+	// is not found in Dir
+	Archive string
+}
+
+// RowError Malformed row found while reading an agency file
+type RowError struct {
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Position Point of an agency file right after one of its rows
+type Position struct {
+	// Offset Bytes of the file up to the end of the row
+	Offset int64 `json:"offset"`
+	// Line Lines of the file up to the end of the row
+	Line int `json:"line"`
+	// Rows Rows of the file up to and including the row
+	Rows uint32 `json:"rows"`
+}
+
+// BetReader Iterates lazily over the bets of an agency file. Rows are
+// parsed one at a time, so the file is never held in memory as a whole
+//
+//	for reader.Next() {
+//		bet := reader.Bet()
+//	}
+//	if err := reader.Err(); err != nil { ... }
+type BetReader struct {
+	agency  string
+	source  string
+	input   io.Reader
+	closers []io.Closer
+	lines   *lineReader
+	csv     *csv.Reader
+	// base Position the reader started at
+	base Position
+	rows uint32
+	bet  protocol.Bet
+	err  error
+}
+
+// AgencyFileName Name of the bets file of an agency
+func AgencyFileName(agency string) string {
+	return fmt.Sprintf("agency-%s.csv", agency)
+}
+
+// OpenBetReader Opens the bets file of the agency. The file is looked up
+// in the data directory first and then, if not found, inside the data
+// archive, which is decompressed on the fly while reading
+func OpenBetReader(config DataConfig, agency string) (*BetReader, error) {
+	name := AgencyFileName(agency)
+
+	if config.Dir != "" {
+		path := filepath.Join(config.Dir, name)
+		file, err := os.Open(path)
+		if err == nil {
+			// Bind mounting a file that does not exist on the host leaves
+			// an empty directory in its place
+			if info, statErr := file.Stat(); statErr == nil && info.IsDir() {
+				file.Close()
+				return nil, fmt.Errorf("agency file %s is a directory, check it exists where it is mounted from", path)
+			}
+			return newBetReader(agency, path, file, file), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) || config.Archive == "" {
+			return nil, err
+		}
+	}
+
+	if config.Archive == "" {
+		return nil, fmt.Errorf("no data directory nor archive configured to find %s", name)
+	}
+
+	archive, err := zip.OpenReader(config.Archive)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := archive.Open(name)
+	if err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("could not find %s in %s: %w", name, config.Archive, err)
+	}
+	return newBetReader(agency, config.Archive+":"+name, entry, entry, archive), nil
+}
+
+func newBetReader(agency, source string, r io.Reader, closers ...io.Closer) *BetReader {
+	reader := &BetReader{
+		agency:  agency,
+		source:  source,
+		input:   r,
+		closers: closers,
+	}
+	reader.reset()
+	return reader
+}
+
+// reset Starts parsing the input from its current position
+func (r *BetReader) reset() {
+	r.lines = &lineReader{r: bufio.NewReader(r.input)}
+	r.csv = csv.NewReader(r.lines)
+	r.csv.FieldsPerRecord = betFields
+	r.csv.ReuseRecord = true
+}
+
+// Position Position of the file right after the row of the last bet read
+func (r *BetReader) Position() Position {
+	return Position{
+		Offset: r.base.Offset + r.lines.offset,
+		Line:   r.base.Line + r.lines.lines,
+		Rows:   r.base.Rows + r.rows,
+	}
+}
+
+// Seek Moves the reader to a position previously returned by Position,
+// so the next bet read is the one of the following row. It must be called
+// before reading any bet. Files are seeked, while archive entries, which
+// are compressed, are read up to the position
+func (r *BetReader) Seek(pos Position) error {
+	if r.rows != 0 || r.lines.offset != 0 {
+		return errors.New("cannot seek after reading bets")
+	}
+
+	if seeker, ok := r.input.(io.Seeker); ok {
+		if _, err := seeker.Seek(pos.Offset, io.SeekStart); err != nil {
+			return err
+		}
+		r.reset()
+		r.base = pos
+		return nil
+	}
+
+	for r.rows < pos.Rows && r.Next() {
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if current := r.Position(); current != pos {
+		return fmt.Errorf("%s does not match the position to resume from: expected %+v, got %+v", r.source, pos, current)
+	}
+	return nil
+}
+
+// Source Path of the file being read, used for logging purposes
+func (r *BetReader) Source() string {
+	return r.source
+}
+
+// Next Advances to the next bet of the file. It returns false when the
+// file was completely read or when a malformed row is found, in which
+// case Err reports the row line
+func (r *BetReader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+
+	record, err := r.csv.Read()
+	if err == io.EOF {
+		return false
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			r.err = &RowError{Line: r.base.Line + parseErr.Line, Err: parseErr.Err}
+		} else {
+			r.err = err
+		}
+		return false
+	}
+
+	bet, err := protocol.NewBet(r.agency, record[0], record[1], record[2], record[3], record[4])
+	if err != nil {
+		line, _ := r.csv.FieldPos(0)
+		r.err = &RowError{Line: r.base.Line + line, Err: err}
+		return false
+	}
+
+	r.bet = bet
+	r.rows++
+	return true
+}
+
+// Bet Bet read by the last successful call to Next
+func (r *BetReader) Bet() protocol.Bet {
+	return r.bet
+}
+
+// Err First error found while reading, if any
+func (r *BetReader) Err() error {
+	return r.err
+}
+
+// Close Releases the file and archive handles held by the reader
+func (r *BetReader) Close() error {
+	var firstErr error
+	for _, c := range r.closers {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// lineReader Hands out its input one line at a time and counts the bytes
+// and lines handed out. csv.Reader reads through a bufio.Reader, which
+// only reads again once the lines it holds are consumed, so the counts
+// match exactly the rows parsed so far
+type lineReader struct {
+	r       *bufio.Reader
+	pending []byte
+	err     error
+	offset  int64
+	lines   int
+}
+
+func (l *lineReader) Read(p []byte) (int, error) {
+	if len(l.pending) == 0 {
+		if l.err != nil {
+			return 0, l.err
+		}
+		line, err := l.r.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			l.err = err
+		}
+		if len(line) == 0 {
+			return 0, l.err
+		}
+		l.pending = line
+	}
+
+	n := copy(p, l.pending)
+	l.offset += int64(n)
+	l.lines += bytes.Count(l.pending[:n], []byte{'\n'})
+	l.pending = l.pending[n:]
+	return n, nil
+}
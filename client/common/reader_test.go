@@ -0,0 +1,153 @@
+package common
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const agencyRows = "Santiago Lionel,Lorca,30904465,1999-03-17,2201\n" +
+	"Agustin Emanuel,Zambrano,21689196,2000-05-10,9325\n"
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("could not write %s: %v", path, err)
+	}
+}
+
+func writeArchive(t *testing.T, path, name, content string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("could not create %s: %v", path, err)
+	}
+	defer file.Close()
+	w := zip.NewWriter(file)
+	entry, err := w.Create(name)
+	if err != nil {
+		t.Fatalf("could not create zip entry: %v", err)
+	}
+	if _, err := entry.Write([]byte(content)); err != nil {
+		t.Fatalf("could not write zip entry: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("could not close zip: %v", err)
+	}
+}
+
+func readAll(t *testing.T, r *BetReader) int {
+	t.Helper()
+	count := 0
+	for r.Next() {
+		if r.Bet().Agency != 1 {
+			t.Fatalf("expected agency 1, got %d", r.Bet().Agency)
+		}
+		count++
+	}
+	return count
+}
+
+func TestBetReaderReadsFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "agency-1.csv"), agencyRows)
+
+	r, err := OpenBetReader(DataConfig{Dir: dir}, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	if n := readAll(t, r); n != 2 || r.Err() != nil {
+		t.Fatalf("expected 2 bets and no error, got %d and %v", n, r.Err())
+	}
+}
+
+func TestBetReaderRejectsDirectoryInPlaceOfFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "agency-6.csv"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := OpenBetReader(DataConfig{Dir: dir}, "6")
+	if err == nil || !strings.Contains(err.Error(), "is a directory") {
+		t.Fatalf("expected an error naming the directory, got %v", err)
+	}
+}
+
+func TestBetReaderFallsBackToArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dataset.zip")
+	writeArchive(t, archive, "agency-1.csv", agencyRows)
+
+	r, err := OpenBetReader(DataConfig{Dir: dir, Archive: archive}, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	if n := readAll(t, r); n != 2 || r.Err() != nil {
+		t.Fatalf("expected 2 bets and no error, got %d and %v", n, r.Err())
+	}
+}
+
+func TestBetReaderReportsMalformedRowLine(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "agency-1.csv"), agencyRows+"Tiago,Rivera,34407251,29/08/2001,1033\n")
+
+	r, err := OpenBetReader(DataConfig{Dir: dir}, "1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer r.Close()
+
+	readAll(t, r)
+	var rowErr *RowError
+	if !errors.As(r.Err(), &rowErr) || rowErr.Line != 3 {
+		t.Fatalf("expected an error on line 3, got %v", r.Err())
+	}
+}
+
+func TestBetReaderSeeksToPosition(t *testing.T) {
+	dir := t.TempDir()
+	// The quoted name spans two lines
+	rows := "Santiago Lionel,Lorca,30904465,1999-03-17,2201\n" +
+		"\"Agustin\nEmanuel\",Zambrano,21689196,2000-05-10,9325\n" +
+		"Ana,Perez,123,1990-01-01,12\n"
+	writeFile(t, filepath.Join(dir, "agency-1.csv"), rows)
+	archive := filepath.Join(dir, "dataset.zip")
+	writeArchive(t, archive, "agency-1.csv", rows)
+
+	for _, config := range []DataConfig{{Dir: dir}, {Archive: archive}} {
+		r, err := OpenBetReader(config, "1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		r.Next()
+		r.Next()
+		pos := r.Position()
+		r.Close()
+		expected := Position{Offset: int64(strings.Index(rows, "Ana")), Line: 3, Rows: 2}
+		if pos != expected {
+			t.Fatalf("expected position %+v, got %+v", expected, pos)
+		}
+
+		r, err = OpenBetReader(config, "1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := r.Seek(pos); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !r.Next() || r.Bet().Document != "123" || r.Next() {
+			t.Fatalf("expected only the last bet after seeking, got %+v", r.Bet())
+		}
+		if end := r.Position(); end.Offset != int64(len(rows)) || end.Line != 4 || end.Rows != 3 {
+			t.Fatalf("unexpected end position %+v", end)
+		}
+		r.Close()
+	}
+}
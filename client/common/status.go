@@ -0,0 +1,115 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Phase Stage of the run the client is in
+type Phase string
+
+const (
+	PhaseStarting       Phase = "starting"
+	PhaseUploading      Phase = "uploading"
+	PhaseWaitingWinners Phase = "waiting_winners"
+	PhaseDone           Phase = "done"
+	PhaseFailed         Phase = "failed"
+)
+
+// Status Snapshot of the state of the client, as exposed by its status
+// endpoint
+type Status struct {
+	Phase Phase `json:"phase"`
+	// Ready The first connection to the server succeeded
+	Ready bool `json:"ready"`
+	// Healthy The server is still reachable within the reconnect budget
+	Healthy     bool      `json:"healthy"`
+	BetsSent    int       `json:"bets_sent"`
+	BatchesSent int       `json:"batches_sent"`
+	Winners     *int      `json:"winners,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// statusTracker Status of the client, updated by the loop and read by
+// the HTTP handlers
+type statusTracker struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{status: Status{Phase: PhaseStarting, Healthy: true, UpdatedAt: time.Now()}}
+}
+
+func (t *statusTracker) update(f func(s *Status)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.status)
+	t.status.UpdatedAt = time.Now()
+}
+
+func (t *statusTracker) snapshot() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status := t.status
+	if status.Winners != nil {
+		winners := *status.Winners
+		status.Winners = &winners
+	}
+	return status
+}
+
+func (t *statusTracker) setPhase(phase Phase) {
+	t.update(func(s *Status) { s.Phase = phase })
+}
+
+// connected Records a successful dial, which makes the client ready
+func (t *statusTracker) connected() {
+	t.update(func(s *Status) {
+		s.Ready = true
+		s.Healthy = true
+	})
+}
+
+// unreachable Records a dial that exhausted the reconnect budget
+func (t *statusTracker) unreachable(err error) {
+	t.update(func(s *Status) {
+		s.Healthy = false
+		s.LastError = err.Error()
+	})
+}
+
+func (t *statusTracker) batchSent(bets int) {
+	t.update(func(s *Status) {
+		s.BatchesSent++
+		s.BetsSent += bets
+	})
+}
+
+func (t *statusTracker) done(winners int) {
+	t.update(func(s *Status) {
+		s.Phase = PhaseDone
+		s.Winners = &winners
+	})
+}
+
+// fail Records the error that ended the run. Runs aborted by a graceful
+// shutdown did not fail, so their phase is kept
+func (t *statusTracker) fail(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	t.update(func(s *Status) {
+		s.Phase = PhaseFailed
+		s.LastError = err.Error()
+	})
+}
+
+// Status Current state of the client. It is safe to call while the
+// client loop is running
+func (c *Client) Status() Status {
+	return c.status.snapshot()
+}
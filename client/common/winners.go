@@ -0,0 +1,105 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// WinnersMode Strategy used to wait for the lottery results
+type WinnersMode string
+
+const (
+	// WinnersModeWait Sends a single query over a connection that is held
+	// open until the server answers once the lottery is done
+	WinnersModeWait WinnersMode = "wait"
+	// WinnersModePoll Queries the server in a new connection every
+	// cooldown until the lottery is done
+	WinnersModePoll WinnersMode = "poll"
+)
+
+// WinnersConfig Configuration of the winners query
+type WinnersConfig struct {
+	Mode WinnersMode
+	// Cooldown Time waited before querying again when the lottery is
+	// not done yet
+	Cooldown time.Duration
+	// Timeout Total time allowed to obtain the winners
+	Timeout time.Duration
+}
+
+// ParseWinnersMode Validates a winners mode read from the configuration
+func ParseWinnersMode(mode string) (WinnersMode, error) {
+	switch WinnersMode(mode) {
+	case WinnersModeWait, WinnersModePoll:
+		return WinnersMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid winners mode %q: must be %q or %q", mode, WinnersModeWait, WinnersModePoll)
+	}
+}
+
+// queryWinners Asks the server for the winners of the agency until the
+// lottery is done or the configured timeout expires. Replies saying the
+// lottery is not done yet are retried after the cooldown
+func (c *Client) queryWinners(ctx context.Context, agency uint8) ([]string, error) {
+	deadline := time.Now().Add(c.config.Winners.Timeout)
+
+	if c.config.Winners.Mode == WinnersModeWait {
+		if err := c.acquireConnection(ctx); err != nil {
+			return nil, err
+		}
+		defer c.releaseConnection()
+	}
+
+	for attempt := 1; ; attempt++ {
+		winners, err := c.requestWinners(ctx, agency, deadline)
+		if err == nil {
+			return winners, nil
+		}
+		if !errors.Is(err, protocol.ErrLotteryNotDone) {
+			return nil, err
+		}
+
+		if time.Now().Add(c.config.Winners.Cooldown).After(deadline) {
+			return nil, fmt.Errorf("lottery not done after %v: %w", c.config.Winners.Timeout, err)
+		}
+		logs.Debug("consulta_ganadores", logs.InProgress, ClientID(c.config.ID), logs.Any("attempt", attempt))
+		if err := sleep(ctx, c.config.Winners.Cooldown); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// requestWinners Sends a single winners query, whose reply is awaited
+// until the deadline. In poll mode every query uses its own connection
+// unless the client is persistent, while in wait mode the connection
+// acquired by queryWinners is reused
+func (c *Client) requestWinners(ctx context.Context, agency uint8, deadline time.Time) ([]string, error) {
+	if c.config.Winners.Mode == WinnersModePoll {
+		if err := c.acquireConnection(ctx); err != nil {
+			return nil, err
+		}
+		defer c.releaseConnection()
+	}
+
+	reply, err := c.exchangeUntil(ctx, &protocol.GetWinnersPacket{AgencyID: agency}, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	switch p := reply.(type) {
+	case *protocol.ReplyWinnersPacket:
+		if p.AgencyID != agency {
+			return nil, fmt.Errorf("received winners of agency %d instead of %d", p.AgencyID, agency)
+		}
+		return p.Winners, nil
+	case *protocol.ErrorPacket:
+		return nil, fmt.Errorf("%v packet rejected: %w", protocol.MsgGetWinners, p)
+	default:
+		return nil, fmt.Errorf("unexpected %v packet in response to %v packet", reply.Type(), protocol.MsgGetWinners)
+	}
+}
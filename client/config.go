@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Config Typed configuration of the client, decoded from the merged
+// config file and env variables
+type Config struct {
+	ID         string           `mapstructure:"id"`
+	Server     serverConfig     `mapstructure:"server"`
+	Reconnect  reconnectConfig  `mapstructure:"reconnect"`
+	Loop       loopConfig       `mapstructure:"loop"`
+	Log        logConfig        `mapstructure:"log"`
+	Batch      batchConfig      `mapstructure:"batch"`
+	Winners    winnersConfig    `mapstructure:"winners"`
+	Data       dataConfig       `mapstructure:"data"`
+	Metrics    metricsConfig    `mapstructure:"metrics"`
+	Health     healthConfig     `mapstructure:"health"`
+	Checkpoint checkpointConfig `mapstructure:"checkpoint"`
+}
+
+type serverConfig struct {
+	Address        string        `mapstructure:"address"`
+	ConnectTimeout time.Duration `mapstructure:"connectTimeout"`
+	ReadTimeout    time.Duration `mapstructure:"readTimeout"`
+	WriteTimeout   time.Duration `mapstructure:"writeTimeout"`
+	Persistent     bool          `mapstructure:"persistent"`
+	KeepAlive      time.Duration `mapstructure:"keepAlive"`
+}
+
+type reconnectConfig struct {
+	MaxAttempts    int           `mapstructure:"maxAttempts"`
+	InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+	MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+}
+
+type loopConfig struct {
+	Period time.Duration `mapstructure:"period"`
+}
+
+type logConfig struct {
+	Level  string `mapstructure:"level"`
+	Format string `mapstructure:"format"`
+	// File Log file written alongside stdout, disabled if empty
+	File     string `mapstructure:"file"`
+	MaxBytes int64  `mapstructure:"maxBytes"`
+	MaxFiles int    `mapstructure:"maxFiles"`
+	Compress bool   `mapstructure:"compress"`
+}
+
+type batchConfig struct {
+	MaxAmount int `mapstructure:"maxAmount"`
+	MaxBytes  int `mapstructure:"maxBytes"`
+}
+
+type winnersConfig struct {
+	Mode     string        `mapstructure:"mode"`
+	Cooldown time.Duration `mapstructure:"cooldown"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+}
+
+type dataConfig struct {
+	Dir     string `mapstructure:"dir"`
+	Archive string `mapstructure:"archive"`
+}
+
+type metricsConfig struct {
+	// Address Address the metrics are served on, disabled if empty
+	Address string `mapstructure:"address"`
+}
+
+type healthConfig struct {
+	// Address Address the health endpoints are served on, disabled if
+	// empty. It may be the same as metrics.address
+	Address string `mapstructure:"address"`
+	// Linger Time the endpoints keep being served once the client loop
+	// ends, so its final status can be read
+	Linger time.Duration `mapstructure:"linger"`
+}
+
+type checkpointConfig struct {
+	// File Upload checkpoint written after every acknowledged batch.
+	// Checkpoints are opt-in, so it is empty and disabled by default
+	File string `mapstructure:"file"`
+	// Resume Skips the rows acknowledged according to the checkpoint
+	Resume bool `mapstructure:"resume"`
+}
+
+// configDefaults Values used for the keys missing in both the config
+// file and the environment. id and server.address have no default
+var configDefaults = map[string]interface{}{
+	"server.connectTimeout":    common.DefaultConnectTimeout,
+	"server.readTimeout":       common.DefaultReadTimeout,
+	"server.writeTimeout":      common.DefaultWriteTimeout,
+	"server.persistent":        false,
+	"server.keepAlive":         15 * time.Second,
+	"reconnect.maxAttempts":    common.DefaultDialMaxAttempts,
+	"reconnect.initialBackoff": common.DefaultDialInitialBackoff,
+	"reconnect.maxBackoff":     common.DefaultDialMaxBackoff,
+	"loop.period":              time.Duration(0),
+	"log.level":                "INFO",
+	"log.format":               logs.FormatText,
+	"log.file":                 "",
+	"log.maxBytes":             10 << 20,
+	"log.maxFiles":             5,
+	"log.compress":             false,
+	"batch.maxAmount":          common.DefaultBatchMaxAmount,
+	"batch.maxBytes":           common.DefaultBatchMaxBytes,
+	"winners.mode":             string(common.WinnersModePoll),
+	"winners.cooldown":         3 * time.Second,
+	"winners.timeout":          time.Minute,
+	"data.dir":                 "./.data",
+	"data.archive":             "./.data/dataset.zip",
+	"metrics.address":          "",
+	"health.address":           "",
+	"health.linger":            time.Duration(0),
+	"checkpoint.file":          "",
+	"checkpoint.resume":        false,
+}
+
+// quotedKey Extracts the key a decoding or validation problem refers to
+var quotedKey = regexp.MustCompile(`'([^']+)'`)
+
+// minBatchBytes Smallest batch size able to hold any valid bet
+const minBatchBytes = protocol.HeaderSize + protocol.BetPacketHeaderSize + protocol.MaxBetSize
+
+// ConfigError Every problem found while loading the configuration, so
+// all of them can be fixed at once
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("invalid configuration:\n  - %s", strings.Join(e.Problems, "\n  - "))
+}
+
+// DecodeConfig Decodes the merged configuration held by v into a Config
+// and validates it. Every decoding and validation problem is reported
+// in a single ConfigError
+func DecodeConfig(v *viper.Viper) (*Config, error) {
+	var config Config
+	var problems []string
+	undecoded := make(map[string]bool)
+
+	err := v.Unmarshal(&config, viper.DecodeHook(mapstructure.StringToTimeDurationHookFunc()))
+	if err != nil {
+		var decodeErr *mapstructure.Error
+		if !errors.As(err, &decodeErr) {
+			return nil, err
+		}
+		for _, problem := range decodeErr.Errors {
+			problems = append(problems, problem)
+			if key := quotedKey.FindStringSubmatch(problem); key != nil {
+				undecoded[key[1]] = true
+			}
+		}
+	}
+
+	// Keys that could not be decoded were already reported, so they are
+	// not validated again
+	for _, problem := range config.validate() {
+		if key := quotedKey.FindStringSubmatch(problem); key == nil || !undecoded[key[1]] {
+			problems = append(problems, problem)
+		}
+	}
+	if len(problems) > 0 {
+		return nil, &ConfigError{Problems: problems}
+	}
+	return &config, nil
+}
+
+// validate Checks required keys and value ranges, returning a message
+// for every invalid key
+func (c *Config) validate() []string {
+	var problems []string
+	check := func(ok bool, key, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf("'%s' %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	if c.ID == "" {
+		check(false, "id", "is required")
+	} else {
+		agency, err := strconv.ParseUint(c.ID, 10, 8)
+		check(err == nil && agency > 0, "id", "must be an integer between 1 and 255, got %q", c.ID)
+	}
+	check(c.Server.Address != "", "server.address", "is required")
+	check(c.Server.ConnectTimeout > 0, "server.connectTimeout", "must be greater than 0, got %v", c.Server.ConnectTimeout)
+	check(c.Server.ReadTimeout > 0, "server.readTimeout", "must be greater than 0, got %v", c.Server.ReadTimeout)
+	check(c.Server.WriteTimeout > 0, "server.writeTimeout", "must be greater than 0, got %v", c.Server.WriteTimeout)
+
+	check(c.Reconnect.MaxAttempts > 0, "reconnect.maxAttempts", "must be greater than 0, got %v", c.Reconnect.MaxAttempts)
+	check(c.Reconnect.InitialBackoff > 0, "reconnect.initialBackoff", "must be greater than 0, got %v", c.Reconnect.InitialBackoff)
+	check(c.Reconnect.MaxBackoff >= c.Reconnect.InitialBackoff, "reconnect.maxBackoff",
+		"must not be lower than reconnect.initialBackoff, got %v", c.Reconnect.MaxBackoff)
+
+	check(c.Loop.Period >= 0, "loop.period", "must not be negative, got %v", c.Loop.Period)
+
+	_, err := logs.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level", "must be one of CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG, got %q", c.Log.Level)
+	_, err = logs.NewFormatter(c.Log.Format)
+	check(err == nil, "log.format", "must be %q or %q, got %q", logs.FormatText, logs.FormatJSON, c.Log.Format)
+	if c.Log.File != "" {
+		check(c.Log.MaxBytes > 0, "log.maxBytes", "must be greater than 0, got %v", c.Log.MaxBytes)
+		check(c.Log.MaxFiles >= 0, "log.maxFiles", "must not be negative, got %v", c.Log.MaxFiles)
+	}
+
+	check(c.Batch.MaxAmount > 0, "batch.maxAmount", "must be greater than 0, got %v", c.Batch.MaxAmount)
+	check(c.Batch.MaxBytes >= minBatchBytes && c.Batch.MaxBytes <= protocol.MaxPayloadSize, "batch.maxBytes",
+		"must be between %d and %d, got %v", minBatchBytes, protocol.MaxPayloadSize, c.Batch.MaxBytes)
+
+	_, err = common.ParseWinnersMode(c.Winners.Mode)
+	check(err == nil, "winners.mode", "must be %q or %q, got %q", common.WinnersModeWait, common.WinnersModePoll, c.Winners.Mode)
+	check(c.Winners.Cooldown >= 0, "winners.cooldown", "must not be negative, got %v", c.Winners.Cooldown)
+	check(c.Winners.Timeout > 0, "winners.timeout", "must be greater than 0, got %v", c.Winners.Timeout)
+
+	check(c.Health.Linger >= 0, "health.linger", "must not be negative, got %v", c.Health.Linger)
+
+	check(!c.Checkpoint.Resume || c.Checkpoint.File != "", "checkpoint.resume", "requires 'checkpoint.file'")
+
+	check(c.Data.Dir != "" || c.Data.Archive != "", "data", "requires either 'data.dir' or 'data.archive'")
+	return problems
+}
+
+// ClientConfig Configuration of the client entity derived from the
+// program configuration
+func (c *Config) ClientConfig() common.ClientConfig {
+	return common.ClientConfig{
+		ID:            c.ID,
+		ServerAddress: c.Server.Address,
+		Dial: common.DialConfig{
+			Timeout:        c.Server.ConnectTimeout,
+			MaxAttempts:    c.Reconnect.MaxAttempts,
+			InitialBackoff: c.Reconnect.InitialBackoff,
+			MaxBackoff:     c.Reconnect.MaxBackoff,
+			KeepAlive:      c.Server.KeepAlive,
+		},
+		Persistent:   c.Server.Persistent,
+		ReadTimeout:  c.Server.ReadTimeout,
+		WriteTimeout: c.Server.WriteTimeout,
+		LoopPeriod:   c.Loop.Period,
+		Batch:        c.ReloadableConfig().Batch,
+		Winners: common.WinnersConfig{
+			Mode:     common.WinnersMode(c.Winners.Mode),
+			Cooldown: c.Winners.Cooldown,
+			Timeout:  c.Winners.Timeout,
+		},
+		Data: common.DataConfig{
+			Dir:     c.Data.Dir,
+			Archive: c.Data.Archive,
+		},
+		Checkpoint: common.CheckpointConfig{
+			Path:   c.Checkpoint.File,
+			Resume: c.Checkpoint.Resume,
+		},
+	}
+}
+
+// ReloadableConfig Part of the client configuration that can be applied
+// while the client is running
+func (c *Config) ReloadableConfig() common.ReloadableConfig {
+	return common.ReloadableConfig{
+		LoopPeriod: c.Loop.Period,
+		Batch: common.BatchConfig{
+			MaxAmount: c.Batch.MaxAmount,
+			MaxBytes:  c.Batch.MaxBytes,
+		},
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func newTestViper(values map[string]interface{}) *viper.Viper {
+	v := viper.New()
+	for key, value := range configDefaults {
+		v.SetDefault(key, value)
+	}
+	for key, value := range values {
+		v.Set(key, value)
+	}
+	return v
+}
+
+func TestDecodeConfigKeepsValues(t *testing.T) {
+	v := newTestViper(map[string]interface{}{
+		"id":              "3",
+		"server.address":  "server:12345",
+		"loop.period":     "2s",
+		"batch.maxAmount": "50",
+	})
+
+	config, err := DecodeConfig(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.ID != "3" || config.Server.Address != "server:12345" || config.Loop.Period != 2*time.Second || config.Batch.MaxAmount != 50 {
+		t.Fatalf("values were not kept: %+v", config)
+	}
+}
+
+func TestDecodeConfigReportsEveryInvalidKey(t *testing.T) {
+	v := newTestViper(map[string]interface{}{
+		"server.readTimeout": "abc",
+		"batch.maxAmount":    -3,
+		"winners.mode":       "push",
+	})
+
+	_, err := DecodeConfig(v)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected a ConfigError, got %v", err)
+	}
+
+	for _, key := range []string{"id", "server.address", "server.readTimeout", "batch.maxAmount", "winners.mode"} {
+		found := 0
+		for _, problem := range configErr.Problems {
+			if strings.Contains(problem, "'"+key+"'") {
+				found++
+			}
+		}
+		if found != 1 {
+			t.Errorf("expected one problem for %s, got %d in %v", key, found, configErr.Problems)
+		}
+	}
+}
+
+func TestDecodeConfigLeavesCheckpointsOptIn(t *testing.T) {
+	config, err := DecodeConfig(newTestViper(map[string]interface{}{"id": "1", "server.address": "server:12345"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Checkpoint.File != "" {
+		t.Fatalf("expected checkpoints to be disabled by default, got %s", config.Checkpoint.File)
+	}
+
+	_, err = DecodeConfig(newTestViper(map[string]interface{}{"id": "1", "server.address": "server:12345", "checkpoint.resume": true}))
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || len(configErr.Problems) != 1 || !strings.Contains(configErr.Problems[0], "'checkpoint.resume'") {
+		t.Fatalf("expected resuming without a checkpoint file to be rejected, got %v", err)
+	}
+}
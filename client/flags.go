@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// defaultConfigFile Config file read when --config is not given
+const defaultConfigFile = "./config.yaml"
+
+// flagKeys Config keys that can be overridden from the command line,
+// indexed by flag name
+var flagKeys = map[string]string{
+	"id":             "id",
+	"server-address": "server.address",
+	"log-level":      "log.level",
+	"data-dir":       "data.dir",
+	"resume":         "checkpoint.resume",
+}
+
+// NewFlagSet Defines the command line flags of the client. Flags take
+// precedence over env variables, the config file and defaults
+func NewFlagSet() *pflag.FlagSet {
+	flags := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	flags.String("config", defaultConfigFile, "path of the YAML config file")
+	flags.String("id", "", "agency id of the client (env CLI_ID)")
+	flags.String("server-address", "", "address of the lottery server as host:port (env CLI_SERVER_ADDRESS)")
+	flags.String("log-level", "", "one of CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG (env CLI_LOG_LEVEL)")
+	flags.String("data-dir", "", "directory holding the agency-{id}.csv files (env CLI_DATA_DIR)")
+	flags.Bool("resume", false, "skip the rows acknowledged according to the checkpoint of a previous run (requires checkpoint.file, env CLI_CHECKPOINT_RESUME)")
+	flags.Bool("print-config", false, "print the effective configuration and the source of each key, then exit")
+	return flags
+}
+
+// bindFlags Makes the flags override their config keys in v
+func bindFlags(v *viper.Viper, flags *pflag.FlagSet) error {
+	for name, key := range flagKeys {
+		if err := v.BindPFlag(key, flags.Lookup(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configKeys Every key of the client configuration, sorted
+func configKeys() []string {
+	keys := []string{"id", "server.address"}
+	for key := range configDefaults {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// envName Env variable that overrides the given config key
+func envName(key string) string {
+	return "CLI_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// configSource Where the effective value of the key comes from, following
+// the precedence flag > env > file > default. file must hold only the
+// settings read from the config file
+func configSource(flags *pflag.FlagSet, file *viper.Viper, key string) string {
+	for name, flagKey := range flagKeys {
+		if flagKey == key && flags.Changed(name) {
+			return "flag --" + name
+		}
+	}
+	if _, ok := os.LookupEnv(envName(key)); ok {
+		return "env " + envName(key)
+	}
+	if file.IsSet(key) {
+		return "file " + file.ConfigFileUsed()
+	}
+	if _, ok := configDefaults[key]; ok {
+		return "default"
+	}
+	return "unset"
+}
+
+// PrintEffectiveConfig Writes every config key with its effective value
+// and the source it was taken from
+func PrintEffectiveConfig(w io.Writer, v *viper.Viper, flags *pflag.FlagSet) {
+	file := fileSettings(v)
+	for _, key := range configKeys() {
+		fmt.Fprintf(w, "%s = %v (%s)\n", key, v.Get(key), configSource(flags, file, key))
+	}
+}
+
+// fileSettings Reads again the config file used by v, without defaults nor
+// overrides, so the keys set in the file can be told apart. Nested keys
+// cannot be checked with InConfig
+func fileSettings(v *viper.Viper) *viper.Viper {
+	file := viper.New()
+	if path := v.ConfigFileUsed(); path != "" {
+		file.SetConfigFile(path)
+		// A file that could not be read sets no key
+		_ = file.ReadInConfig()
+	}
+	return file
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "server:\n  address: file:1\nlog:\n  level: WARNING\nloop:\n  period: 1s\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLI_SERVER_ADDRESS", "env:1")
+	t.Setenv("CLI_LOG_LEVEL", "ERROR")
+
+	flags := NewFlagSet()
+	if err := flags.Parse([]string{"--config", path, "--id", "2", "--log-level", "DEBUG"}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := InitConfig(flags)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file := fileSettings(v)
+	expected := map[string]struct{ value, source string }{
+		"id":             {"2", "flag --id"},
+		"log.level":      {"DEBUG", "flag --log-level"},
+		"server.address": {"env:1", "env CLI_SERVER_ADDRESS"},
+		"loop.period":    {"1s", "file " + path},
+		"batch.maxBytes": {"8000", "default"},
+	}
+	for key, want := range expected {
+		if value := v.GetString(key); value != want.value {
+			t.Errorf("%s: expected value %s, got %s", key, want.value, value)
+		}
+		if source := configSource(flags, file, key); source != want.source {
+			t.Errorf("%s: expected source %s, got %s", key, want.source, source)
+		}
+	}
+}
+
+func TestInitConfigFailsOnMissingExplicitFile(t *testing.T) {
+	flags := NewFlagSet()
+	if err := flags.Parse([]string{"--config", filepath.Join(t.TempDir(), "missing.yaml")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := InitConfig(flags); err == nil {
+		t.Fatal("expected an error for a missing explicit config file")
+	}
+}
+
+func TestInitConfigReportsMissingFileWithoutExtension(t *testing.T) {
+	flags := NewFlagSet()
+	if err := flags.Parse([]string{"--config", filepath.Join(t.TempDir(), "nonexistent")}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := InitConfig(flags)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a file not found error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected the error to mention the missing config file, got %v", err)
+	}
+}
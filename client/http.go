@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/httpserver"
+)
+
+// httpMuxes Handlers to be served, indexed by address, so endpoints
+// configured on the same address share a listener
+type httpMuxes map[string]*http.ServeMux
+
+// at Mux served on the given address
+func (m httpMuxes) at(address string) *http.ServeMux {
+	mux, ok := m[address]
+	if !ok {
+		mux = http.NewServeMux()
+		m[address] = mux
+	}
+	return mux
+}
+
+// start Starts a server on every address, stopping them all when ctx is
+// cancelled
+func (m httpMuxes) start(ctx context.Context) error {
+	for address, mux := range m {
+		if _, err := httpserver.Start(ctx, address, mux); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerHealthHandlers Adds the health endpoints to mux, all of them
+// answered from the status of the client:
+//   - /healthz fails once the server cannot be reached within the
+//     reconnect budget
+//   - /readyz succeeds once the first connection to the server succeeds
+//   - /status reports the whole status as JSON
+func registerHealthHandlers(mux *http.ServeMux, status func() common.Status) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeCheck(w, status().Healthy)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeCheck(w, status().Ready)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status())
+	})
+}
+
+func writeCheck(w http.ResponseWriter, ok bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("unavailable\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
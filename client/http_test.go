@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	status := common.Status{Phase: common.PhaseUploading, Ready: false, Healthy: true, BetsSent: 10}
+	mux := http.NewServeMux()
+	registerHealthHandlers(mux, func() common.Status { return status })
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if code := get("/healthz").StatusCode; code != http.StatusOK {
+		t.Errorf("expected healthy, got %d", code)
+	}
+	if code := get("/readyz").StatusCode; code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready, got %d", code)
+	}
+
+	var got common.Status
+	if err := json.NewDecoder(get("/status").Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Phase != common.PhaseUploading || got.BetsSent != 10 {
+		t.Fatalf("unexpected status %+v", got)
+	}
+
+	status.Ready, status.Healthy = true, false
+	if code := get("/readyz").StatusCode; code != http.StatusOK {
+		t.Errorf("expected ready, got %d", code)
+	}
+	if code := get("/healthz").StatusCode; code != http.StatusServiceUnavailable {
+		t.Errorf("expected unhealthy, got %d", code)
+	}
+}
@@ -1,26 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/op/go-logging"
-	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
 )
 
-var log = logging.MustGetLogger("log")
-
 // InitConfig Function that uses viper library to parse configuration parameters.
-// Viper is configured to read variables from both environment variables and the
-// config file ./config.yaml. Environment variables takes precedence over parameters
-// defined in the configuration file. If some of the variables cannot be parsed,
-// an error is returned
-func InitConfig() (*viper.Viper, error) {
+// Viper is configured to read variables from command line flags, environment
+// variables and the config file given by --config (./config.yaml by default).
+// The precedence is flag > env > file > default. The returned viper instance
+// holds the merged configuration, to be decoded with DecodeConfig. An error is
+// returned if the flags cannot be bound or an explicitly given config file
+// cannot be read
+func InitConfig(flags *pflag.FlagSet) (*viper.Viper, error) {
 	v := viper.New()
 
 	// Configure viper to read env variables with the CLI_ prefix
@@ -31,85 +35,162 @@ func InitConfig() (*viper.Viper, error) {
 	// env variables for the nested configurations
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
-	// Add env variables supported
+	// Add env variables supported. Every key must be bound so it is taken
+	// into account when decoding the configuration
 	v.BindEnv("id")
-	v.BindEnv("server", "address")
-	v.BindEnv("loop", "period")
-	v.BindEnv("loop", "amount")
-	v.BindEnv("log", "level")
+	for key, value := range configDefaults {
+		v.SetDefault(key, value)
+		v.BindEnv(key)
+	}
+	v.BindEnv("server.address")
+
+	if err := bindFlags(v, flags); err != nil {
+		return nil, err
+	}
 
-	// Try to read configuration from config file. If config file
+	// Try to read configuration from config file. If the default config file
 	// does not exists then ReadInConfig will fail but configuration
 	// can be loaded from the environment variables so we shouldn't
 	// return an error in that case
-	v.SetConfigFile("./config.yaml")
-	if err := v.ReadInConfig(); err != nil {
-		fmt.Printf("Configuration could not be read from config file. Using env variables instead")
+	configFile, _ := flags.GetString("config")
+	v.SetConfigFile(configFile)
+	// Viper guesses the file type from its extension before opening it, so
+	// a missing file without one would be reported as an unsupported type
+	var err error
+	if _, err = os.Stat(configFile); err != nil {
+		err = fmt.Errorf("config file %s not found: %w", configFile, err)
+	} else if err = v.ReadInConfig(); err != nil {
+		err = fmt.Errorf("could not read config file %s: %w", configFile, err)
 	}
-
-	// Parse time.Duration variables and return an error if those variables cannot be parsed
-
-	if _, err := time.ParseDuration(v.GetString("loop.period")); err != nil {
-		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
+	if err != nil {
+		if flags.Changed("config") {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Configuration could not be read from config file. Using env variables instead: %v\n", err)
 	}
 
 	return v, nil
 }
 
-// InitLogger Receives the log level to be set in go-logging as a string. This method
-// parses the string and set the level to the logger. If the level string is not
-// valid an error is returned
-func InitLogger(logLevel string) error {
-	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
-	format := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
-	)
-	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
+// InitLogger Receives the log configuration and sets the level and the
+// format of the logger. Lines are written to stdout and, if log.file is set,
+// to a file rotated by size as well. The returned closer releases the file.
+// If the configuration is not valid an error is returned
+func InitLogger(config logConfig) (io.Closer, error) {
+	if config.File == "" {
+		return closerFunc(func() error { return nil }), logs.Init(os.Stdout, config.Level, config.Format)
+	}
 
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
-	logLevelCode, err := logging.LogLevel(logLevel)
+	file, err := logs.OpenRotatingFile(logs.RotateConfig{
+		Path:     config.File,
+		MaxBytes: config.MaxBytes,
+		MaxFiles: config.MaxFiles,
+		Compress: config.Compress,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	backendLeveled.SetLevel(logLevelCode, "")
-
-	// Set the backends to be used.
-	logging.SetBackend(backendLeveled)
-	return nil
+	if err := logs.Init(io.MultiWriter(os.Stdout, file), config.Level, config.Format); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
 }
 
+// closerFunc Adapts a function to io.Closer
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
-func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s",
-		v.GetString("id"),
-		v.GetString("server.address"),
-		v.GetInt("loop.amount"),
-		v.GetDuration("loop.period"),
-		v.GetString("log.level"),
+func PrintConfig(config *Config) {
+	logs.Info("config", logs.Success,
+		common.ClientID(config.ID),
+		logs.Any("server_address", config.Server.Address),
+		logs.Any("loop_period", config.Loop.Period),
+		logs.Any("log_level", config.Log.Level),
+		logs.Any("log_format", config.Log.Format),
+		logs.Any("log_file", config.Log.File),
+		logs.Any("batch_max_amount", config.Batch.MaxAmount),
+		logs.Any("batch_max_bytes", config.Batch.MaxBytes),
+		logs.Any("winners_mode", config.Winners.Mode),
+		logs.Any("winners_cooldown", config.Winners.Cooldown),
+		logs.Any("winners_timeout", config.Winners.Timeout),
+		logs.Any("metrics_address", config.Metrics.Address),
+		logs.Any("health_address", config.Health.Address),
+		logs.Any("checkpoint_file", config.Checkpoint.File),
+		logs.Any("checkpoint_resume", config.Checkpoint.Resume),
 	)
 }
 
 func main() {
-	v, err := InitConfig()
+	flags := NewFlagSet()
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return
+		}
+		os.Exit(2)
+	}
+
+	v, err := InitConfig(flags)
 	if err != nil {
-		log.Criticalf("%s", err)
+		logs.Critical("config", logs.Fail, logs.Err(err))
+		os.Exit(1)
 	}
 
-	if err := InitLogger(v.GetString("log.level")); err != nil {
-		log.Criticalf("%s", err)
+	// The effective configuration is printed even if it is invalid, so the
+	// source of a wrong value can be found
+	config, err := DecodeConfig(v)
+	printConfig, _ := flags.GetBool("print-config")
+	if printConfig {
+		PrintEffectiveConfig(os.Stdout, v, flags)
+	}
+	if err != nil {
+		logs.Critical("config", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+	if printConfig {
+		return
 	}
 
+	logFile, err := InitLogger(config.Log)
+	if err != nil {
+		logs.Critical("init_logger", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+	defer logFile.Close()
+
 	// Print program config with debugging purposes
-	PrintConfig(v)
+	PrintConfig(config)
+
+	// Cancel the client context on SIGTERM or SIGINT so the client can
+	// release its resources before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
 
-	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopAmount:    v.GetInt("loop.amount"),
-		LoopPeriod:    v.GetDuration("loop.period"),
+	client := common.NewClient(config.ClientConfig())
+	muxes := make(httpMuxes)
+	if config.Metrics.Address != "" {
+		muxes.at(config.Metrics.Address).Handle("/metrics", client.Metrics())
+	}
+	if config.Health.Address != "" {
+		registerHealthHandlers(muxes.at(config.Health.Address), client.Status)
 	}
+	if err := muxes.start(ctx); err != nil {
+		logs.Critical("http_server", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+
+	// Changes to the config file apply to the running client, so it can be
+	// tuned without rebuilding the image nor restarting the container
+	WatchConfig(v, client)
+	client.StartClientLoop(ctx)
 
-	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	if config.Health.Address != "" && config.Health.Linger > 0 {
+		select {
+		case <-ctx.Done():
+		case <-time.After(config.Health.Linger):
+		}
+	}
 }
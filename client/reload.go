@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+)
+
+// reloadableKeys Config keys applied while the client is running. Changes
+// to any other key require a restart
+var reloadableKeys = map[string]bool{
+	"log.level":       true,
+	"loop.period":     true,
+	"batch.maxAmount": true,
+	"batch.maxBytes":  true,
+}
+
+// configWatcher Applies the reloadable keys of the configuration held by
+// v every time the config file changes
+type configWatcher struct {
+	v *viper.Viper
+	// applied Effective value of every key in use by the client
+	applied map[string]string
+	// apply Hands the reloaded configuration to the client
+	apply func(common.ReloadableConfig)
+}
+
+// WatchConfig Watches the config file read by v, so changes to log.level,
+// loop.period and the batch limits apply to the running client from its
+// next batch on. Changes to any other key are logged and ignored
+func WatchConfig(v *viper.Viper, client *common.Client) {
+	path := v.ConfigFileUsed()
+	if _, err := os.Stat(path); err != nil {
+		logs.Warning("watch_config", logs.Fail, logs.Err(err))
+		return
+	}
+
+	w := newConfigWatcher(v, client.Reload)
+	v.OnConfigChange(func(fsnotify.Event) { w.reload() })
+	v.WatchConfig()
+	logs.Info("watch_config", logs.Success, logs.Any("file", path))
+}
+
+func newConfigWatcher(v *viper.Viper, apply func(common.ReloadableConfig)) *configWatcher {
+	return &configWatcher{
+		v:       v,
+		applied: configValues(v),
+		apply:   apply,
+	}
+}
+
+// reload Decodes the configuration again and applies its reloadable keys.
+// An invalid configuration is ignored as a whole
+func (w *configWatcher) reload() {
+	config, err := DecodeConfig(w.v)
+	if err != nil {
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			err = fmt.Errorf("%s", strings.Join(configErr.Problems, "; "))
+		}
+		logs.Error("reload_config", logs.Fail, logs.Err(err))
+		return
+	}
+
+	values := configValues(w.v)
+	for _, key := range configKeys() {
+		if values[key] == w.applied[key] {
+			continue
+		}
+		if !reloadableKeys[key] {
+			logs.Warning("reload_config", logs.Ignored,
+				logs.Any("key", key),
+				logs.Err(errors.New("key is not reloadable, restart the client to apply it")),
+			)
+			continue
+		}
+		w.applied[key] = values[key]
+	}
+
+	// The level was already validated when decoding
+	level, _ := logs.ParseLevel(config.Log.Level)
+	logs.SetLevel(level)
+	w.apply(config.ReloadableConfig())
+
+	logs.Info("reload_config", logs.Success,
+		logs.Any("log_level", config.Log.Level),
+		logs.Any("loop_period", config.Loop.Period),
+		logs.Any("batch_max_amount", config.Batch.MaxAmount),
+		logs.Any("batch_max_bytes", config.Batch.MaxBytes),
+	)
+}
+
+// configValues Effective value of every config key held by v
+func configValues(v *viper.Viper) map[string]string {
+	values := make(map[string]string)
+	for _, key := range configKeys() {
+		values[key] = fmt.Sprint(v.Get(key))
+	}
+	return values
+}
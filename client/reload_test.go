@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+)
+
+func TestConfigWatcherAppliesReloadableKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("id: 1\nserver:\n  address: server:12345\nloop:\n  period: 1s\n")
+
+	flags := NewFlagSet()
+	if err := flags.Parse([]string{"--config", path}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := InitConfig(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var applied []common.ReloadableConfig
+	w := newConfigWatcher(v, func(config common.ReloadableConfig) { applied = append(applied, config) })
+
+	reload := func(content string) {
+		t.Helper()
+		write(content)
+		if err := v.ReadInConfig(); err != nil {
+			t.Fatal(err)
+		}
+		w.reload()
+	}
+
+	reload("id: 2\nserver:\n  address: server:12345\nloop:\n  period: 3s\nbatch:\n  maxAmount: 10\n")
+	if len(applied) != 1 || applied[0].LoopPeriod != 3*time.Second || applied[0].Batch.MaxAmount != 10 {
+		t.Fatalf("reloadable keys were not applied: %+v", applied)
+	}
+	if w.applied["id"] != "1" || w.applied["loop.period"] != "3s" {
+		t.Fatalf("expected id to be kept and loop.period to be updated, got %v", w.applied)
+	}
+
+	// Invalid configurations are not applied at all
+	reload("id: 2\nserver:\n  address: server:12345\nloop:\n  period: -1s\n")
+	if len(applied) != 1 {
+		t.Fatalf("invalid configuration was applied: %+v", applied)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"text/template"
+)
+
+// Subnet Subnet of testing_net, the network every container is attached to
+const Subnet = "172.25.125.0/24"
+
+// MinClients Least amount of clients a compose file can define. The server
+// requires at least one agency to draw the lottery
+const MinClients = 1
+
+// MaxClients Most clients a compose file can define: one per address of
+// Subnet left after the gateway and the server
+var MaxClients = subnetHosts(Subnet) - 2
+
+// subnetHosts Addresses of the subnet that can be assigned to hosts, which
+// excludes the network and broadcast addresses
+func subnetHosts(subnet string) int {
+	_, network, err := net.ParseCIDR(subnet)
+	if err != nil {
+		panic(fmt.Sprintf("invalid subnet %q: %v", subnet, err))
+	}
+	ones, bits := network.Mask.Size()
+	return 1<<(bits-ones) - 2
+}
+
+// composeTemplate Definition of the server and the clients, matching
+// docker-compose-dev.yaml. Config files and the dataset are mounted from
+// the repository root, so compose must be run from there. Every client
+// reads its agency file from the dataset archive, which holds the files
+// of every agency
+var composeTemplate = template.Must(template.New("compose").Funcs(template.FuncMap{
+	"subnet": func() string { return Subnet },
+}).Parse(`name: tp0
+services:
+  server:
+    container_name: server
+    image: server-go:latest
+    entrypoint: /server
+    environment:
+      - LOGGING_LEVEL=DEBUG
+      - AGENCY_AMOUNT={{ len . }}
+    volumes:
+      - ./cmd/server/config.ini:/config.ini
+    networks:
+      - testing_net
+{{ range . }}
+  client{{ . }}:
+    container_name: client{{ . }}
+    image: client:latest
+    entrypoint: /client
+    environment:
+      - CLI_ID={{ . }}
+      - CLI_LOG_LEVEL=DEBUG
+    volumes:
+      - ./client/config.yaml:/config.yaml
+      - ./.data/dataset.zip:/.data/dataset.zip:ro
+    networks:
+      - testing_net
+    depends_on:
+      - server
+{{ end }}
+networks:
+  testing_net:
+    ipam:
+      driver: default
+      config:
+        - subnet: {{ subnet }}
+`))
+
+// ValidateClients Checks the amount of clients is between MinClients and
+// MaxClients
+func ValidateClients(clients int) error {
+	if clients < MinClients || clients > MaxClients {
+		return fmt.Errorf("amount of clients must be between %d and %d, got %d", MinClients, MaxClients, clients)
+	}
+	return nil
+}
+
+// GenerateCompose Writes to w a compose definition with the server and
+// the given amount of clients, named client1 to clientN
+func GenerateCompose(w io.Writer, clients int) error {
+	if err := ValidateClients(clients); err != nil {
+		return err
+	}
+	ids := make([]int, clients)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	return composeTemplate.Execute(w, ids)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the generated output")
+
+func TestGenerateComposeMatchesGoldenFiles(t *testing.T) {
+	for _, clients := range []int{1, 3, 5} {
+		t.Run(fmt.Sprintf("%d clients", clients), func(t *testing.T) {
+			var out bytes.Buffer
+			if err := GenerateCompose(&out, clients); err != nil {
+				t.Fatalf("failed to generate compose: %v", err)
+			}
+
+			golden := filepath.Join("testdata", fmt.Sprintf("clients-%d.golden.yaml", clients))
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatalf("failed to update golden file: %v", err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file: %v", err)
+			}
+			if !bytes.Equal(out.Bytes(), expected) {
+				t.Errorf("generated compose does not match %s, got:\n%s", golden, out.String())
+			}
+		})
+	}
+}
+
+func TestMaxClientsFitsTheSubnet(t *testing.T) {
+	// A /24 has 254 host addresses, one of them taken by the gateway and
+	// another one by the server
+	if MaxClients != 252 {
+		t.Fatalf("expected 252 clients to fit in %s, got %d", Subnet, MaxClients)
+	}
+}
+
+func TestGenerateComposeRejectsClientsOutOfRange(t *testing.T) {
+	for _, clients := range []int{-1, 0, MaxClients + 1, 255} {
+		var out bytes.Buffer
+		if err := GenerateCompose(&out, clients); err == nil {
+			t.Errorf("expected %d clients to be rejected", clients)
+		}
+		if out.Len() != 0 {
+			t.Errorf("expected nothing to be written for %d clients, got %q", clients, out.String())
+		}
+	}
+	for _, clients := range []int{MinClients, MaxClients} {
+		if err := ValidateClients(clients); err != nil {
+			t.Errorf("expected %d clients to be accepted: %v", clients, err)
+		}
+	}
+}
+
+func TestWriteComposeDoesNotCreateFileOnInvalidClients(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := writeCompose(path, 0); err == nil {
+		t.Fatal("expected 0 clients to be rejected")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no file to be created, got %v", err)
+	}
+}
+
+func TestWriteComposeMatchesDevCompose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "compose.yaml")
+	if err := writeCompose(path, 1); err != nil {
+		t.Fatalf("failed to write compose: %v", err)
+	}
+	generated, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read compose: %v", err)
+	}
+	dev, err := os.ReadFile(filepath.Join("..", "..", "docker-compose-dev.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read docker-compose-dev.yaml: %v", err)
+	}
+	if !bytes.Equal(generated, dev) {
+		t.Errorf("docker-compose-dev.yaml is out of date, regenerate it with make docker-compose-dev")
+	}
+}
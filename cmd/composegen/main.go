@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const usage = "usage: composegen <output-file> <clients>"
+
+// writeCompose Generates the compose definition into a file at path
+func writeCompose(path string, clients int) error {
+	if err := ValidateClients(clients); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	if err := GenerateCompose(writer, clients); err != nil {
+		file.Close()
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	output := os.Args[1]
+	clients, err := strconv.Atoi(os.Args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "amount of clients must be an integer, got %q\n%s\n", os.Args[2], usage)
+		os.Exit(2)
+	}
+
+	if err := writeCompose(output, clients); err != nil {
+		fmt.Fprintf(os.Stderr, "could not generate %s: %v\n", output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Nombre del archivo de salida: %s\n", output)
+	fmt.Printf("Cantidad de clientes: %d\n", clients)
+}
@@ -0,0 +1,21 @@
+// Package bettest Bets shared by the tests of the server packages
+package bettest
+
+import (
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// New Valid bet with the given fields, born on 1999-03-17 and surnamed
+// Lorca
+func New(agency uint8, firstName string, document string, number uint16) protocol.Bet {
+	return protocol.Bet{
+		Agency:    agency,
+		FirstName: firstName,
+		LastName:  "Lorca",
+		Document:  document,
+		Birthdate: time.Date(1999, 3, 17, 0, 0, 0, 0, time.UTC),
+		Number:    number,
+	}
+}
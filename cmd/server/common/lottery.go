@@ -0,0 +1,114 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+)
+
+// ErrLotteryNotDone Winners were requested before the draw
+var ErrLotteryNotDone = errors.New("lottery not done")
+
+// DrawFunc Runs the draw, returning the documents of the winners of every
+// agency
+type DrawFunc func() (map[uint8][]string, error)
+
+// Lottery Coordinates the draw: it is run once, as soon as every agency
+// has finished its upload session, and winners are only handed out once
+// it is done, so no query ever sees partial results. It is safe for
+// concurrent use
+type Lottery struct {
+	agencies int
+	draw     DrawFunc
+
+	mu       sync.Mutex
+	finished map[uint8]bool
+	winners  map[uint8][]string
+	err      error
+	// done Closed once the draw was run, successfully or not
+	done chan struct{}
+}
+
+// NewLottery Initializes a lottery that is drawn once the given amount of
+// agencies have finished
+func NewLottery(agencies int, draw DrawFunc) *Lottery {
+	return &Lottery{
+		agencies: agencies,
+		draw:     draw,
+		finished: make(map[uint8]bool),
+		done:     make(chan struct{}),
+	}
+}
+
+// Finish Records that the agency sent all its bets. The call that
+// completes the amount of agencies runs the draw before returning. Agencies
+// finishing more than once are only counted once
+func (l *Lottery) Finish(agency uint8) {
+	l.mu.Lock()
+	if l.finished[agency] {
+		l.mu.Unlock()
+		return
+	}
+	l.finished[agency] = true
+	finished := len(l.finished)
+	l.mu.Unlock()
+
+	logs.Info("agencia_finalizada", logs.Success,
+		logs.Any("agency", agency),
+		logs.Any("finished", finished),
+		logs.Any("agencies", l.agencies),
+	)
+	if finished == l.agencies {
+		l.runDraw()
+	}
+}
+
+func (l *Lottery) runDraw() {
+	winners, err := l.draw()
+
+	l.mu.Lock()
+	l.winners, l.err = winners, err
+	l.mu.Unlock()
+	close(l.done)
+
+	if err != nil {
+		logs.Error("sorteo", logs.Fail, logs.Err(err))
+		return
+	}
+	logs.Info("sorteo", logs.Success)
+}
+
+// Winners Documents of the winners of the agency. If the draw is not done
+// yet, it is awaited for up to wait, after which ErrLotteryNotDone is
+// returned. Cancelling ctx stops the wait too
+func (l *Lottery) Winners(ctx context.Context, agency uint8, wait time.Duration) ([]string, error) {
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-l.done:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+
+	select {
+	case <-l.done:
+	default:
+		return nil, ErrLotteryNotDone
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return nil, l.err
+	}
+	winners := l.winners[agency]
+	if winners == nil {
+		winners = []string{}
+	}
+	return winners, nil
+}
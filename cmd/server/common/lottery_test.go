@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLotteryDrawsOnceEveryAgencyFinished(t *testing.T) {
+	draws := 0
+	lottery := NewLottery(2, func() (map[uint8][]string, error) {
+		draws++
+		return map[uint8][]string{1: {"10", "20"}}, nil
+	})
+
+	lottery.Finish(1)
+	lottery.Finish(1)
+	if _, err := lottery.Winners(context.Background(), 1, 0); !errors.Is(err, ErrLotteryNotDone) || draws != 0 {
+		t.Fatalf("expected the lottery not to be drawn, got %v after %d draws", err, draws)
+	}
+
+	lottery.Finish(2)
+	lottery.Finish(2)
+	winners, err := lottery.Winners(context.Background(), 1, 0)
+	if err != nil || len(winners) != 2 || draws != 1 {
+		t.Fatalf("expected 2 winners from a single draw, got %v, %v after %d draws", winners, err, draws)
+	}
+	if winners, err := lottery.Winners(context.Background(), 2, 0); err != nil || winners == nil || len(winners) != 0 {
+		t.Fatalf("expected no winners for agency 2, got %v, %v", winners, err)
+	}
+}
+
+func TestLotteryWinnersWaitIsBounded(t *testing.T) {
+	lottery := NewLottery(1, func() (map[uint8][]string, error) { return nil, nil })
+
+	start := time.Now()
+	if _, err := lottery.Winners(context.Background(), 1, 20*time.Millisecond); !errors.Is(err, ErrLotteryNotDone) {
+		t.Fatalf("expected the lottery not to be drawn, got %v", err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expected the query to wait for the draw")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := lottery.Winners(ctx, 1, time.Minute); !errors.Is(err, ErrLotteryNotDone) {
+		t.Fatalf("expected the wait to stop with ctx, got %v", err)
+	}
+}
+
+func TestLotteryReportsDrawFailures(t *testing.T) {
+	failure := errors.New("corrupt storage")
+	lottery := NewLottery(1, func() (map[uint8][]string, error) { return nil, failure })
+	lottery.Finish(1)
+
+	if _, err := lottery.Winners(context.Background(), 1, 0); !errors.Is(err, failure) {
+		t.Fatalf("expected the draw failure, got %v", err)
+	}
+}
@@ -0,0 +1,357 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/storage"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/metrics"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const (
+	// DefaultIdleTimeout Time a client may take to send its next packet
+	DefaultIdleTimeout = time.Minute
+	// DefaultMaxConnections Clients served at the same time
+	DefaultMaxConnections = 10
+	// DefaultAgencies Agencies that must finish before the draw
+	DefaultAgencies = 5
+	// DefaultWinnersWait Time a winners query waits for the draw. It is
+	// below the default read timeout of the client, so the query is
+	// answered before the client gives up on it
+	DefaultWinnersWait = 10 * time.Second
+)
+
+// ServerConfig Configuration used by the server
+type ServerConfig struct {
+	// Address Address the server listens on, as host:port
+	Address string
+	// IdleTimeout Time a client may take to send its next packet before
+	// its connection is closed
+	IdleTimeout time.Duration
+	// MaxConnections Clients served at the same time. Once reached, no
+	// connection is accepted until one of them is closed
+	MaxConnections int
+	// Agencies Agencies that must finish their upload session before the
+	// lottery is drawn
+	Agencies int
+	// WinnerNumber Bets on this number win the lottery
+	WinnerNumber uint16
+	// WinnersWait Time a winners query waits for the draw before being
+	// answered with a lottery not done error. Zero answers right away.
+	// Waiting queries hold their connection, so with fewer MaxConnections
+	// than Agencies some agencies may not be able to finish
+	WinnersWait time.Duration
+}
+
+// Server Lottery server that stores the bets sent by the agencies
+type Server struct {
+	config   ServerConfig
+	listener net.Listener
+	// store Where the received bets are stored. It is shared by every
+	// connection
+	store   storage.BetStore
+	lottery *Lottery
+	// ingestMu Guards stored, so checking the sequence number of a batch
+	// and storing it happen as one step
+	ingestMu sync.Mutex
+	// stored Sequence number of the last batch stored per agency, which is
+	// also how many of its bets were stored. It is persisted by the store
+	// along with the bets, so it survives restarts
+	stored map[uint8]uint32
+
+	registry    *metrics.Registry
+	connections *metrics.Gauge
+}
+
+// NewServer Initializes the server socket, so clients can connect as
+// soon as it returns. Received bets are appended to store, which is not
+// closed by the server. The progress of the uploads is loaded from store,
+// so uploads interrupted before a restart can be resumed. Bets stored by
+// other means, such as an older run or an import, are not part of any
+// upload, so they are not counted
+func NewServer(config ServerConfig, store storage.BetStore) (*Server, error) {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.MaxConnections <= 0 {
+		config.MaxConnections = DefaultMaxConnections
+	}
+	if config.Agencies <= 0 {
+		config.Agencies = DefaultAgencies
+	}
+	stored, err := store.Progress()
+	if err != nil {
+		return nil, fmt.Errorf("could not load the progress of the uploads: %w", err)
+	}
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	registry := metrics.NewRegistry()
+	s := &Server{
+		config:      config,
+		listener:    listener,
+		store:       store,
+		stored:      stored,
+		registry:    registry,
+		connections: registry.NewGauge("server_connections", "Client connections currently being served."),
+	}
+	s.lottery = NewLottery(config.Agencies, s.draw)
+	return s, nil
+}
+
+// Metrics Registry of the metrics updated by the server, to be exposed
+// to Prometheus
+func (s *Server) Metrics() *metrics.Registry {
+	return s.registry
+}
+
+// Addr Address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Run Server loop. Accepts new connections and serves each client in its
+// own goroutine, up to MaxConnections at the same time. While the limit is
+// reached no connection is accepted, so further clients wait in the listen
+// backlog. Cancelling ctx closes the server socket and every connection
+// being served, and Run returns once all of them are closed
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.listener.Close()
+	}()
+
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+
+	slots := make(chan struct{}, s.config.MaxConnections)
+	for {
+		select {
+		case slots <- struct{}{}:
+		default:
+			logs.Warning("accept_connections", logs.InProgress,
+				logs.Any("connections", s.connections.Value()),
+				logs.Err(errors.New("connection limit reached, waiting for a client to disconnect")),
+			)
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				logs.Info("shutdown", logs.Success)
+				return nil
+			}
+		}
+
+		logs.Info("accept_connections", logs.InProgress)
+		conn, err := s.listener.Accept()
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				logs.Info("shutdown", logs.Success)
+				return nil
+			}
+			logs.Error("accept_connections", logs.Fail, logs.Err(err))
+			return err
+		}
+		logs.Info("accept_connections", logs.Success, logs.Any("ip", remoteIP(conn)))
+
+		s.connections.Inc()
+		handlers.Add(1)
+		go func() {
+			defer func() {
+				s.connections.Dec()
+				<-slots
+				handlers.Done()
+			}()
+			s.handleClientConnection(ctx, conn)
+		}()
+	}
+}
+
+// handleClientConnection Serves the packets sent by a client until it
+// disconnects, then closes the connection. If a problem arises in the
+// communication with the client, the connection is closed as well
+func (s *Server) handleClientConnection(ctx context.Context, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	defer conn.Close()
+
+	session := &clientSession{ip: remoteIP(conn)}
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(s.config.IdleTimeout)); err != nil {
+			logs.Error("receive_message", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+			return
+		}
+		frame, err := protocol.ReadFrame(conn)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				logs.Error("receive_message", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+			}
+			return
+		}
+
+		reply, closeAfter := s.handlePacket(ctx, session, frame)
+		if err := protocol.Send(conn, reply); err != nil {
+			logs.Error("send_message", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+			return
+		}
+		if closeAfter {
+			return
+		}
+	}
+}
+
+// clientSession State of the conversation with a client
+type clientSession struct {
+	ip string
+	// agency Agency whose upload session is open, if any
+	agency *uint8
+}
+
+// handlePacket Processes a packet sent by the client and returns the
+// packet to answer with. Packets that cannot be decoded are answered with
+// an error and the connection is to be closed, since the stream may no
+// longer be in sync. Well formed packets carrying invalid bets are just
+// rejected
+func (s *Server) handlePacket(ctx context.Context, session *clientSession, frame protocol.Frame) (protocol.Packet, bool) {
+	packet, err := protocol.Decode(frame)
+	if errors.Is(err, protocol.ErrInvalidBet) {
+		logs.Error("apuesta_recibida", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeInvalidBet, Message: err.Error()}, false
+	}
+	if err != nil {
+		logs.Error("receive_message", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeInvalidPacket, Message: err.Error()}, true
+	}
+
+	switch p := packet.(type) {
+	case *protocol.BetStartPacket:
+		session.agency = &p.AgencyID
+		s.ingestMu.Lock()
+		stored := s.stored[p.AgencyID]
+		s.ingestMu.Unlock()
+		logs.Info("start_session", logs.Success, logs.Any("agency", p.AgencyID), logs.Any("stored", stored), logs.Any("ip", session.ip))
+		return &protocol.ReplyPacket{Count: stored, Message: "OK"}, false
+	case *protocol.BetPacket:
+		return s.storeBatch(session, p), false
+	case *protocol.BetFinishPacket:
+		if session.agency == nil || *session.agency != p.AgencyID {
+			return sessionMismatch(session, p.AgencyID), false
+		}
+		session.agency = nil
+		logs.Info("finish_session", logs.Success, logs.Any("agency", p.AgencyID), logs.Any("ip", session.ip))
+		s.lottery.Finish(p.AgencyID)
+		return &protocol.ReplyPacket{Message: "OK"}, false
+	case *protocol.GetWinnersPacket:
+		return s.winners(ctx, session, p.AgencyID), false
+	default:
+		err := fmt.Errorf("unexpected %v packet", packet.Type())
+		logs.Error("receive_message", logs.Fail, logs.Any("ip", session.ip), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeInvalidPacket, Message: err.Error()}, false
+	}
+}
+
+// storeBatch Stores a batch of bets of the agency of the session. Bets
+// were already validated when decoded. Batches are stored as a whole or
+// not at all. The sequence number of the batch tells which of its bets
+// were already stored, so batches sent again after a lost reply are
+// acknowledged without storing them twice
+func (s *Server) storeBatch(session *clientSession, p *protocol.BetPacket) protocol.Packet {
+	for _, bet := range p.Bets {
+		if session.agency == nil || bet.Agency != *session.agency {
+			return sessionMismatch(session, bet.Agency)
+		}
+	}
+	if session.agency == nil {
+		return sessionMismatch(session, 0)
+	}
+	agency := *session.agency
+	acked := &protocol.ReplyPacket{Count: uint32(len(p.Bets)), Message: "STORED"}
+
+	s.ingestMu.Lock()
+	defer s.ingestMu.Unlock()
+	stored := s.stored[agency]
+	first := p.Seq - uint32(len(p.Bets))
+	if p.Seq <= stored {
+		logs.Info("apuesta_recibida", logs.Ignored, logs.Any("agency", agency), logs.Any("seq", p.Seq), logs.Any("cantidad", len(p.Bets)))
+		return acked
+	}
+	if first > stored {
+		err := fmt.Errorf("batch %d starts after bet %d of the agency, but %d were stored", p.Seq, first, stored)
+		logs.Error("apuesta_recibida", logs.Fail, logs.Any("agency", agency), logs.Any("ip", session.ip), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeOutOfSequence, Message: err.Error()}
+	}
+
+	// The first bets of the batch may have been stored by a previous
+	// attempt if the batch limits changed in between
+	fresh := p.Bets[stored-first:]
+	if err := s.store.AppendBatch(agency, p.Seq, fresh); err != nil {
+		logs.Error("apuesta_recibida", logs.Fail, logs.Any("cantidad", len(fresh)), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeInternal, Message: "could not store bets"}
+	}
+	s.stored[agency] = p.Seq
+	logs.Info("apuesta_recibida", logs.Success, logs.Any("cantidad", len(fresh)))
+	return acked
+}
+
+// winners Answers the winners query of an agency with the documents of
+// its winners, once the lottery was drawn
+func (s *Server) winners(ctx context.Context, session *clientSession, agency uint8) protocol.Packet {
+	winners, err := s.lottery.Winners(ctx, agency, s.config.WinnersWait)
+	if errors.Is(err, ErrLotteryNotDone) {
+		logs.Debug("consulta_ganadores", logs.InProgress, logs.Any("agency", agency), logs.Any("ip", session.ip))
+		return &protocol.ErrorPacket{Code: protocol.CodeLotteryNotDone, Message: "the lottery was not drawn yet"}
+	}
+	if err != nil {
+		logs.Error("consulta_ganadores", logs.Fail, logs.Any("agency", agency), logs.Any("ip", session.ip), logs.Err(err))
+		return &protocol.ErrorPacket{Code: protocol.CodeInternal, Message: "could not draw the lottery"}
+	}
+	logs.Info("consulta_ganadores", logs.Success, logs.Any("agency", agency), logs.Any("cant_ganadores", len(winners)))
+	return &protocol.ReplyWinnersPacket{AgencyID: agency, Winners: winners}
+}
+
+// draw Loads every stored bet and groups the documents of the winners by
+// agency
+func (s *Server) draw() (map[uint8][]string, error) {
+	winners := make(map[uint8][]string)
+	hasWon := func(bet protocol.Bet) bool { return HasWon(bet, s.config.WinnerNumber) }
+	err := s.store.Scan(hasWon, func(bet protocol.Bet) error {
+		winners[bet.Agency] = append(winners[bet.Agency], bet.Document)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return winners, nil
+}
+
+func sessionMismatch(session *clientSession, agency uint8) protocol.Packet {
+	err := errors.New("no upload session was started for the agency")
+	if session.agency != nil {
+		err = fmt.Errorf("upload session belongs to agency %d", *session.agency)
+	}
+	logs.Error("receive_message", logs.Fail, logs.Any("agency", agency), logs.Any("ip", session.ip), logs.Err(err))
+	return &protocol.ErrorPacket{Code: protocol.CodeSessionMismatch, Message: err.Error()}
+}
+
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
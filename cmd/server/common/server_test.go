@@ -0,0 +1,394 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/bettest"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/storage"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func startTestServer(t *testing.T) (*Server, storage.BetStore) {
+	t.Helper()
+	return startTestServerWithLimit(t, 0)
+}
+
+func startTestServerWithLimit(t *testing.T, maxConnections int) (*Server, storage.BetStore) {
+	t.Helper()
+	return startTestServerWithConfig(t, ServerConfig{MaxConnections: maxConnections})
+}
+
+func startTestServerWithConfig(t *testing.T, config ServerConfig) (*Server, storage.BetStore) {
+	t.Helper()
+	store, err := storage.OpenCSVStore(storage.CSVConfig{Path: filepath.Join(t.TempDir(), "bets.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return startTestServerWithStore(t, config, store), store
+}
+
+// startTestServerWithStore Runs a server on store until the test finishes
+func startTestServerWithStore(t *testing.T, config ServerConfig, store storage.BetStore) *Server {
+	t.Helper()
+	config.Address = "127.0.0.1:0"
+	server, err := NewServer(config, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return server
+}
+
+func dialTestServer(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func exchange(t *testing.T, conn net.Conn, request protocol.Packet) protocol.Packet {
+	t.Helper()
+	if err := protocol.Send(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := protocol.Recv(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestServerStoresAcknowledgedBatches(t *testing.T) {
+	server, store := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	if _, ok := exchange(t, conn, &protocol.BetStartPacket{AgencyID: 1}).(*protocol.ReplyPacket); !ok {
+		t.Fatal("expected the session to be started")
+	}
+	bets := []protocol.Bet{bettest.New(1, "Ana", "1", 1), bettest.New(1, "Juan", "2", 7574)}
+	reply, ok := exchange(t, conn, &protocol.BetPacket{Seq: 2, Bets: bets}).(*protocol.ReplyPacket)
+	if !ok || reply.Count != 2 {
+		t.Fatalf("expected the batch to be acknowledged, got %+v", reply)
+	}
+	if _, ok := exchange(t, conn, &protocol.BetFinishPacket{AgencyID: 1}).(*protocol.ReplyPacket); !ok {
+		t.Fatal("expected the session to be finished")
+	}
+
+	stored := 0
+	if err := store.Scan(nil, func(protocol.Bet) error { stored++; return nil }); err != nil || stored != 2 {
+		t.Fatalf("expected 2 stored bets, got %d and %v", stored, err)
+	}
+}
+
+func TestServerRejectsBetsOutsideTheSession(t *testing.T) {
+	server, store := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	reply := exchange(t, conn, &protocol.BetPacket{Seq: 1, Bets: []protocol.Bet{bettest.New(1, "Ana", "1", 1)}})
+	if p, ok := reply.(*protocol.ErrorPacket); !ok || !errors.Is(p, protocol.ErrSessionMismatch) {
+		t.Fatalf("expected a session mismatch, got %+v", reply)
+	}
+
+	exchange(t, conn, &protocol.BetStartPacket{AgencyID: 1})
+	reply = exchange(t, conn, &protocol.BetPacket{Seq: 1, Bets: []protocol.Bet{bettest.New(2, "Ana", "1", 1)}})
+	if p, ok := reply.(*protocol.ErrorPacket); !ok || !errors.Is(p, protocol.ErrSessionMismatch) {
+		t.Fatalf("expected a session mismatch, got %+v", reply)
+	}
+
+	// The client refuses to encode invalid bets, so the number is patched
+	// in the encoded frame
+	frame, err := protocol.Encode(&protocol.BetPacket{Seq: 1, Bets: []protocol.Bet{bettest.New(1, "Ana", "1", 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame.Payload[len(frame.Payload)-2], frame.Payload[len(frame.Payload)-1] = 0xFF, 0xFF
+	if err := protocol.WriteFrame(conn, frame); err != nil {
+		t.Fatal(err)
+	}
+	reply, err = protocol.Recv(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := reply.(*protocol.ErrorPacket); !ok || !errors.Is(p, protocol.ErrInvalidBet) {
+		t.Fatalf("expected an invalid bet, got %+v", reply)
+	}
+
+	if err := store.Scan(nil, func(protocol.Bet) error { return errors.New("no bet was expected") }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerClosesConnectionOnInvalidPacket(t *testing.T) {
+	server, _ := startTestServer(t)
+	conn := dialTestServer(t, server)
+
+	if err := protocol.WriteFrame(conn, protocol.Frame{Type: protocol.MsgBetStart}); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := protocol.Recv(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := reply.(*protocol.ErrorPacket); !ok || !errors.Is(p, protocol.ErrInvalidPacket) {
+		t.Fatalf("expected an invalid packet error, got %+v", reply)
+	}
+	if _, err := protocol.Recv(conn); err == nil {
+		t.Fatal("expected the connection to be closed")
+	}
+}
+
+func TestServerServesClientsConcurrently(t *testing.T) {
+	server, store := startTestServer(t)
+
+	const clients = 5
+	const batches = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for agency := uint8(1); agency <= clients; agency++ {
+		wg.Add(1)
+		go func(agency uint8) {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", server.Addr().String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+
+			if err := protocol.Send(conn, &protocol.BetStartPacket{AgencyID: agency}); err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i <= batches; i++ {
+				if _, err := protocol.Recv(conn); err != nil {
+					errs <- err
+					return
+				}
+				if i == batches {
+					break
+				}
+				bets := []protocol.Bet{bettest.New(agency, strings.Repeat("a", 200), strconv.Itoa(i+1), 1)}
+				if err := protocol.Send(conn, &protocol.BetPacket{Seq: uint32(i + 1), Bets: bets}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(agency)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stored := 0
+	if err := store.Scan(nil, func(protocol.Bet) error { stored++; return nil }); err != nil {
+		t.Fatalf("rows were interleaved: %v", err)
+	}
+	if stored != clients*batches {
+		t.Fatalf("expected %d stored bets, got %d", clients*batches, stored)
+	}
+}
+
+func TestServerDoesNotAcceptOverTheLimit(t *testing.T) {
+	server, _ := startTestServerWithLimit(t, 1)
+
+	first := dialTestServer(t, server)
+	exchange(t, first, &protocol.BetStartPacket{AgencyID: 1})
+	if connections := server.connections.Value(); connections != 1 {
+		t.Fatalf("expected 1 connection being served, got %d", connections)
+	}
+
+	// The second client connects to the backlog but is not served until
+	// the first one disconnects
+	second := dialTestServer(t, server)
+	if err := protocol.Send(second, &protocol.BetStartPacket{AgencyID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	second.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := protocol.Recv(second); err == nil {
+		t.Fatal("expected the second client to wait for the first one")
+	}
+
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := protocol.Recv(second); err != nil {
+		t.Fatalf("expected the second client to be served, got %v", err)
+	}
+}
+
+func uploadTestBets(t *testing.T, conn net.Conn, agency uint8, bets ...protocol.Bet) {
+	t.Helper()
+	exchange(t, conn, &protocol.BetStartPacket{AgencyID: agency})
+	if _, ok := exchange(t, conn, &protocol.BetPacket{Seq: uint32(len(bets)), Bets: bets}).(*protocol.ReplyPacket); !ok {
+		t.Fatal("expected the batch to be acknowledged")
+	}
+	if _, ok := exchange(t, conn, &protocol.BetFinishPacket{AgencyID: agency}).(*protocol.ReplyPacket); !ok {
+		t.Fatal("expected the session to be finished")
+	}
+}
+
+func TestServerAnswersWinnersOnceEveryAgencyFinished(t *testing.T) {
+	server, _ := startTestServerWithConfig(t, ServerConfig{Agencies: 2, WinnerNumber: LotteryWinnerNumber})
+	first, second := dialTestServer(t, server), dialTestServer(t, server)
+
+	uploadTestBets(t, first, 1, bettest.New(1, "Ana", "1", LotteryWinnerNumber), bettest.New(1, "Juan", "2", 1))
+	reply, ok := exchange(t, first, &protocol.GetWinnersPacket{AgencyID: 1}).(*protocol.ErrorPacket)
+	if !ok || reply.Code != protocol.CodeLotteryNotDone {
+		t.Fatalf("expected the lottery not to be done, got %+v", reply)
+	}
+
+	uploadTestBets(t, second, 2, bettest.New(2, "Luis", "3", LotteryWinnerNumber), bettest.New(2, "Eva", "4", LotteryWinnerNumber))
+	for agency, expected := range map[uint8][]string{1: {"1"}, 2: {"3", "4"}} {
+		winners, ok := exchange(t, first, &protocol.GetWinnersPacket{AgencyID: agency}).(*protocol.ReplyWinnersPacket)
+		if !ok || winners.AgencyID != agency || len(winners.Winners) != len(expected) {
+			t.Fatalf("expected winners %v of agency %d, got %+v", expected, agency, winners)
+		}
+		for i := range expected {
+			if winners.Winners[i] != expected[i] {
+				t.Fatalf("expected winners %v of agency %d, got %v", expected, agency, winners.Winners)
+			}
+		}
+	}
+}
+
+func TestServerHoldsWinnersQueriesUntilTheDraw(t *testing.T) {
+	server, _ := startTestServerWithConfig(t, ServerConfig{Agencies: 2, WinnerNumber: LotteryWinnerNumber, WinnersWait: 5 * time.Second})
+	first, second := dialTestServer(t, server), dialTestServer(t, server)
+	uploadTestBets(t, first, 1, bettest.New(1, "Ana", "1", LotteryWinnerNumber))
+
+	replies := make(chan protocol.Packet, 1)
+	go func() {
+		protocol.Send(first, &protocol.GetWinnersPacket{AgencyID: 1})
+		reply, _ := protocol.Recv(first)
+		replies <- reply
+	}()
+	select {
+	case reply := <-replies:
+		t.Fatalf("expected the query to wait for the draw, got %+v", reply)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	uploadTestBets(t, second, 2, bettest.New(2, "Luis", "3", 1))
+	winners, ok := (<-replies).(*protocol.ReplyWinnersPacket)
+	if !ok || len(winners.Winners) != 1 || winners.Winners[0] != "1" {
+		t.Fatalf("expected agency 1 to have won once, got %+v", winners)
+	}
+}
+
+func TestServerStoresReplayedBatchesOnce(t *testing.T) {
+	server, store := startTestServer(t)
+	conn := dialTestServer(t, server)
+	exchange(t, conn, &protocol.BetStartPacket{AgencyID: 1})
+
+	bets := []protocol.Bet{bettest.New(1, "Ana", "1", 1), bettest.New(1, "Juan", "2", 1), bettest.New(1, "Eva", "3", 1)}
+	batches := []*protocol.BetPacket{
+		{Seq: 2, Bets: bets[:2]},
+		// Sent again after a lost reply
+		{Seq: 2, Bets: bets[:2]},
+		// Sent again with smaller batches
+		{Seq: 1, Bets: bets[:1]},
+		// Overlapping the stored bets
+		{Seq: 3, Bets: bets[1:]},
+	}
+	for _, batch := range batches {
+		reply, ok := exchange(t, conn, batch).(*protocol.ReplyPacket)
+		if !ok || reply.Count != uint32(len(batch.Bets)) {
+			t.Fatalf("expected batch %d to be acknowledged, got %+v", batch.Seq, reply)
+		}
+	}
+
+	reply := exchange(t, conn, &protocol.BetPacket{Seq: 5, Bets: bets[:1]})
+	if p, ok := reply.(*protocol.ErrorPacket); !ok || !errors.Is(p, protocol.ErrOutOfSequence) {
+		t.Fatalf("expected a gap to be rejected, got %+v", reply)
+	}
+
+	var documents []string
+	store.Scan(nil, func(bet protocol.Bet) error {
+		documents = append(documents, bet.Document)
+		return nil
+	})
+	if len(documents) != 3 || documents[0] != "1" || documents[1] != "2" || documents[2] != "3" {
+		t.Fatalf("expected every bet to be stored once, got %v", documents)
+	}
+}
+
+func TestServerReportsStoredBetsOnSessionStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	store, err := storage.OpenCSVStore(storage.CSVConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendBatch(1, 2, []protocol.Bet{bettest.New(1, "Ana", "1", 1), bettest.New(1, "Eva", "3", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendBatch(2, 1, []protocol.Bet{bettest.New(2, "Juan", "2", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// The progress must survive a restart of the server
+	store, err = storage.OpenCSVStore(storage.CSVConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	server := startTestServerWithStore(t, ServerConfig{}, store)
+
+	conn := dialTestServer(t, server)
+	if reply, ok := exchange(t, conn, &protocol.BetStartPacket{AgencyID: 1}).(*protocol.ReplyPacket); !ok || reply.Count != 2 {
+		t.Fatalf("expected 2 bets of agency 1 to be stored, got %+v", reply)
+	}
+	if reply, ok := exchange(t, conn, &protocol.BetPacket{Seq: 2, Bets: []protocol.Bet{bettest.New(1, "Eva", "3", 1)}}).(*protocol.ReplyPacket); !ok || reply.Count != 1 {
+		t.Fatalf("expected the stored batch to be acknowledged, got %+v", reply)
+	}
+	if count, _ := store.Count(); count != 3 {
+		t.Fatalf("expected the stored batch not to be stored again, got %d bets", count)
+	}
+}
+
+func TestServerDoesNotCountBetsStoredOutsideAnUpload(t *testing.T) {
+	store, err := storage.OpenCSVStore(storage.CSVConfig{Path: filepath.Join(t.TempDir(), "bets.csv")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	// Rows left by an older run or imported by hand
+	if err := store.Append([]protocol.Bet{bettest.New(1, "Ana", "1", 1), bettest.New(1, "Eva", "3", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	server := startTestServerWithStore(t, ServerConfig{}, store)
+
+	conn := dialTestServer(t, server)
+	if reply, ok := exchange(t, conn, &protocol.BetStartPacket{AgencyID: 1}).(*protocol.ReplyPacket); !ok || reply.Count != 0 {
+		t.Fatalf("expected no bets of agency 1 to be acknowledged, got %+v", reply)
+	}
+	if reply, ok := exchange(t, conn, &protocol.BetPacket{Seq: 1, Bets: []protocol.Bet{bettest.New(1, "Ana", "1", 1)}}).(*protocol.ReplyPacket); !ok || reply.Count != 1 {
+		t.Fatalf("expected the batch to be acknowledged, got %+v", reply)
+	}
+	if count, _ := store.Count(); count != 3 {
+		t.Fatalf("expected the batch to be stored, got %d bets", count)
+	}
+}
@@ -0,0 +1,14 @@
+package common
+
+import (
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// LotteryWinnerNumber Simulated winner number in the lottery contest
+const LotteryWinnerNumber = 7574
+
+// HasWon Checks whether a bet won the prize or not, given the number
+// drawn
+func HasWon(bet protocol.Bet, winnerNumber uint16) bool {
+	return bet.Number == winnerNumber
+}
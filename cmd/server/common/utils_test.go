@@ -0,0 +1,13 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/bettest"
+)
+
+func TestHasWon(t *testing.T) {
+	if !HasWon(bettest.New(1, "A", "1", LotteryWinnerNumber), LotteryWinnerNumber) || HasWon(bettest.New(1, "A", "1", 1), LotteryWinnerNumber) {
+		t.Fatal("only bets on the winner number must win")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/storage"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/httpserver"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// configDefaults Values of the optional keys. SERVER_PORT and
+// LOGGING_LEVEL are required, as in the Python server
+var configDefaults = map[string]string{
+	"STORAGE_BACKEND":         string(storage.BackendCSV),
+	"STORAGE_FILEPATH":        "",
+	"STORAGE_SYNC":            string(storage.SyncInterval),
+	"STORAGE_SYNC_INTERVAL":   storage.DefaultSyncInterval.String(),
+	"CONNECTION_IDLE_TIMEOUT": common.DefaultIdleTimeout.String(),
+	"MAX_CONNECTIONS":         strconv.Itoa(common.DefaultMaxConnections),
+	"METRICS_ADDRESS":         "",
+	"AGENCY_AMOUNT":           strconv.Itoa(common.DefaultAgencies),
+	"WINNER_NUMBER":           strconv.Itoa(common.LotteryWinnerNumber),
+	"WINNERS_WAIT":            common.DefaultWinnersWait.String(),
+}
+
+// Config Configuration parameters of the server
+type Config struct {
+	Server       common.ServerConfig
+	Storage      storage.Config
+	LoggingLevel string
+	// MetricsAddress Address the metrics are served on, disabled if empty
+	MetricsAddress string
+}
+
+// InitConfig Function that uses viper library to parse configuration
+// parameters. Parameters are searched in the environment variables first
+// and then in the DEFAULT section of ./config.ini, the same file the Python
+// server reads, so both servers can be deployed with the same config. If a
+// required parameter is not found or a parameter could not be parsed, an
+// error is returned
+func InitConfig() (*Config, error) {
+	v := viper.New()
+	for _, key := range []string{"SERVER_PORT", "LOGGING_LEVEL", "STORAGE_BACKEND", "STORAGE_FILEPATH", "STORAGE_SYNC", "STORAGE_SYNC_INTERVAL", "CONNECTION_IDLE_TIMEOUT", "MAX_CONNECTIONS", "METRICS_ADDRESS", "AGENCY_AMOUNT", "WINNER_NUMBER", "WINNERS_WAIT"} {
+		// ini sections are loaded as prefixes of their keys
+		v.BindEnv(iniKey(key), key)
+		if value, ok := configDefaults[key]; ok {
+			v.SetDefault(iniKey(key), value)
+		}
+	}
+
+	// If config.ini does not exists the configuration can still be loaded
+	// from the environment variables
+	v.SetConfigFile("./config.ini")
+	if err := v.ReadInConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Configuration could not be read from config file. Using env variables instead: %v\n", err)
+	}
+
+	get := func(key string) (string, error) {
+		value := v.GetString(iniKey(key))
+		if value == "" {
+			return "", fmt.Errorf("key %s was not found", key)
+		}
+		return value, nil
+	}
+
+	port, err := get("SERVER_PORT")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("key SERVER_PORT could not be parsed: %w", err)
+	}
+	level, err := get("LOGGING_LEVEL")
+	if err != nil {
+		return nil, err
+	}
+	backend, err := storage.ParseBackend(v.GetString(iniKey("STORAGE_BACKEND")))
+	if err != nil {
+		return nil, fmt.Errorf("key STORAGE_BACKEND could not be parsed: %w", err)
+	}
+	storagePath := v.GetString(iniKey("STORAGE_FILEPATH"))
+	if storagePath == "" {
+		storagePath = backend.DefaultLocation()
+	}
+	syncPolicy, err := storage.ParseSyncPolicy(v.GetString(iniKey("STORAGE_SYNC")))
+	if err != nil {
+		return nil, fmt.Errorf("key STORAGE_SYNC could not be parsed: %w", err)
+	}
+	syncInterval, err := time.ParseDuration(v.GetString(iniKey("STORAGE_SYNC_INTERVAL")))
+	if err != nil || syncInterval <= 0 {
+		return nil, fmt.Errorf("key STORAGE_SYNC_INTERVAL must be a positive duration, got %q", v.GetString(iniKey("STORAGE_SYNC_INTERVAL")))
+	}
+	idleTimeout, err := time.ParseDuration(v.GetString(iniKey("CONNECTION_IDLE_TIMEOUT")))
+	if err != nil || idleTimeout <= 0 {
+		return nil, fmt.Errorf("key CONNECTION_IDLE_TIMEOUT must be a positive duration, got %q", v.GetString(iniKey("CONNECTION_IDLE_TIMEOUT")))
+	}
+	maxConnections, err := strconv.Atoi(v.GetString(iniKey("MAX_CONNECTIONS")))
+	if err != nil || maxConnections <= 0 {
+		return nil, fmt.Errorf("key MAX_CONNECTIONS must be a positive integer, got %q", v.GetString(iniKey("MAX_CONNECTIONS")))
+	}
+	agencies, err := strconv.Atoi(v.GetString(iniKey("AGENCY_AMOUNT")))
+	if err != nil || agencies <= 0 {
+		return nil, fmt.Errorf("key AGENCY_AMOUNT must be a positive integer, got %q", v.GetString(iniKey("AGENCY_AMOUNT")))
+	}
+	winnerNumber, err := strconv.ParseUint(v.GetString(iniKey("WINNER_NUMBER")), 10, 16)
+	if err != nil || winnerNumber > protocol.MaxBetNumber {
+		return nil, fmt.Errorf("key WINNER_NUMBER must be an integer between 0 and %d, got %q", protocol.MaxBetNumber, v.GetString(iniKey("WINNER_NUMBER")))
+	}
+	winnersWait, err := time.ParseDuration(v.GetString(iniKey("WINNERS_WAIT")))
+	if err != nil || winnersWait < 0 {
+		return nil, fmt.Errorf("key WINNERS_WAIT must be a non negative duration, got %q", v.GetString(iniKey("WINNERS_WAIT")))
+	}
+
+	return &Config{
+		Server: common.ServerConfig{
+			Address:        ":" + port,
+			IdleTimeout:    idleTimeout,
+			MaxConnections: maxConnections,
+			Agencies:       agencies,
+			WinnerNumber:   uint16(winnerNumber),
+			WinnersWait:    winnersWait,
+		},
+		Storage: storage.Config{
+			Backend:      backend,
+			Path:         storagePath,
+			Sync:         syncPolicy,
+			SyncInterval: syncInterval,
+		},
+		LoggingLevel:   level,
+		MetricsAddress: v.GetString(iniKey("METRICS_ADDRESS")),
+	}, nil
+}
+
+// iniKey Viper key of a parameter of the DEFAULT section of config.ini
+func iniKey(key string) string {
+	return "default." + strings.ToLower(key)
+}
+
+// PrintConfig Print all the configuration parameters of the program.
+// For debugging purposes only
+func PrintConfig(config *Config) {
+	logs.Debug("config", logs.Success,
+		logs.Any("address", config.Server.Address),
+		logs.Any("storage_backend", config.Storage.Backend),
+		logs.Any("storage_filepath", config.Storage.Path),
+		logs.Any("storage_sync", config.Storage.Sync),
+		logs.Any("storage_sync_interval", config.Storage.SyncInterval),
+		logs.Any("connection_idle_timeout", config.Server.IdleTimeout),
+		logs.Any("max_connections", config.Server.MaxConnections),
+		logs.Any("agency_amount", config.Server.Agencies),
+		logs.Any("winner_number", config.Server.WinnerNumber),
+		logs.Any("winners_wait", config.Server.WinnersWait),
+		logs.Any("metrics_address", config.MetricsAddress),
+		logs.Any("logging_level", config.LoggingLevel),
+	)
+}
+
+func main() {
+	config, err := InitConfig()
+	if err != nil {
+		logs.Critical("config", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+
+	if err := logs.Init(os.Stdout, config.LoggingLevel, logs.FormatText); err != nil {
+		logs.Critical("init_logger", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+
+	// Log config parameters at the beginning of the program to verify the
+	// configuration of the component
+	PrintConfig(config)
+
+	// Cancel the server context on SIGTERM or SIGINT so the server can
+	// release its resources before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	store, err := storage.Open(config.Storage)
+	if err != nil {
+		logs.Critical("open_store", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+	server, err := common.NewServer(config.Server, store)
+	if err != nil {
+		logs.Critical("listen", logs.Fail, logs.Err(err))
+		os.Exit(1)
+	}
+	if config.MetricsAddress != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", server.Metrics())
+		if _, err := httpserver.Start(ctx, config.MetricsAddress, mux); err != nil {
+			logs.Critical("metrics_server", logs.Fail, logs.Err(err))
+			os.Exit(1)
+		}
+	}
+	err = server.Run(ctx)
+
+	// Flush the stored bets before exiting, since os.Exit skips deferred
+	// calls
+	if closeErr := store.Close(); closeErr != nil {
+		logs.Error("close_store", logs.Fail, logs.Err(closeErr))
+		os.Exit(1)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// CSVConfig Configuration of a CSVStore
+type CSVConfig struct {
+	// Path File the bets are stored in
+	Path string
+	Sync SyncPolicy
+	// SyncInterval Period of the flushes of the interval sync policy
+	SyncInterval time.Duration
+}
+
+// withDefaults Replaces zero values by their defaults
+func (c CSVConfig) withDefaults() CSVConfig {
+	if c.Sync == "" {
+		c.Sync = SyncInterval
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = DefaultSyncInterval
+	}
+	return c
+}
+
+// CSVStore Stores bets in a CSV file with the layout of the store_bets
+// function of the Python server, a csv.writer with QUOTE_MINIMAL, so both
+// servers can read each other's files. Appends are serialized by a mutex
+// within the process and by an advisory lock on the file across processes,
+// so rows of different batches never interleave.
+// The progress of the uploads, which the Python server has no notion of,
+// is kept in a sidecar file next to the CSV, named after it with a .seq
+// suffix. It is replaced after the rows of each batch are written, so a
+// crash in between leaves the batch stored but not acknowledged, and it
+// is stored again when the agency resends it
+type CSVStore struct {
+	config CSVConfig
+	mu     sync.RWMutex
+	// dirty Whether batches were appended since the file was last flushed
+	dirty bool
+	// progress Sequence number of the last batch stored per agency, as
+	// found in the sidecar file
+	progress map[uint8]uint32
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenCSVStore Opens the store at the configured path, creating the file
+// if it does not exist
+func OpenCSVStore(config CSVConfig) (*CSVStore, error) {
+	config = config.withDefaults()
+	file, err := os.OpenFile(config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	progress, err := readProgress(progressPath(config.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &CSVStore{config: config, progress: progress}
+	if config.Sync == SyncInterval {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// Append Writes the rows of every bet with a single write
+func (s *CSVStore) Append(bets []protocol.Bet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appendRows(bets)
+}
+
+// AppendBatch Writes the rows of every bet, then records seq as the
+// progress of agency in the sidecar file
+func (s *CSVStore) AppendBatch(agency uint8, seq uint32, bets []protocol.Bet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.appendRows(bets); err != nil {
+		return err
+	}
+
+	progress := make(map[uint8]uint32, len(s.progress)+1)
+	for a, stored := range s.progress {
+		progress[a] = stored
+	}
+	progress[agency] = seq
+	if err := s.writeProgress(progress); err != nil {
+		return fmt.Errorf("could not record the progress of agency %d: %w", agency, err)
+	}
+	s.progress = progress
+	return nil
+}
+
+// Progress Progress of the uploads read from the sidecar file when the
+// store was opened, plus the batches appended since
+func (s *CSVStore) Progress() (map[uint8]uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	progress := make(map[uint8]uint32, len(s.progress))
+	for agency, seq := range s.progress {
+		progress[agency] = seq
+	}
+	return progress, nil
+}
+
+// appendRows Writes the rows of every bet with a single write. Must be
+// called with the lock held
+func (s *CSVStore) appendRows(bets []protocol.Bet) error {
+	var buf bytes.Buffer
+	for _, bet := range bets {
+		writeRow(&buf,
+			strconv.Itoa(int(bet.Agency)),
+			bet.FirstName,
+			bet.LastName,
+			bet.Document,
+			bet.Birthdate.Format(protocol.DateLayout),
+			strconv.Itoa(int(bet.Number)),
+		)
+	}
+
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(file, true); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if s.config.Sync == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+	return file.Close()
+}
+
+// Scan Reads the file from the beginning. Appends wait for the scan to end
+func (s *CSVStore) Scan(filter Filter, fn func(protocol.Bet) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	file, err := os.Open(s.config.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := lockFile(file, false); err != nil {
+		return err
+	}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 6
+	reader.ReuseRecord = true
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		bet, err := protocol.NewBet(row[0], row[1], row[2], row[3], row[4], row[5])
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("%s:%d: %w", s.config.Path, line, err)
+		}
+		if filter != nil && !filter(bet) {
+			continue
+		}
+		if err := fn(bet); err != nil {
+			return err
+		}
+	}
+}
+
+// Count Scans the whole file, since other processes may append to it
+func (s *CSVStore) Count() (int, error) {
+	count := 0
+	err := s.Scan(nil, func(protocol.Bet) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// Close Flushes the batches appended since the last flush. The file is
+// only open while in use, so there is nothing else to release
+func (s *CSVStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+// syncLoop Flushes the appended batches every SyncInterval until the
+// store is closed
+func (s *CSVStore) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if err := s.flush(); err != nil {
+			logs.Error("sync_store", logs.Fail, logs.Any("file", s.config.Path), logs.Err(err))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// flush Flushes the file to disk if batches were appended since the last
+// flush. Syncing any descriptor of the file flushes the writes done
+// through the ones already closed. Must be called with the lock held
+func (s *CSVStore) flush() error {
+	if !s.dirty {
+		return nil
+	}
+	file, err := os.Open(s.config.Path)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		s.dirty = false
+	}
+	return err
+}
+
+// writeProgress Replaces the sidecar file with progress. It is written to
+// a temporary file renamed over the old one, so a crash leaves either of
+// them whole. Must be called with the lock held
+func (s *CSVStore) writeProgress(progress map[uint8]uint32) error {
+	agencies := make([]int, 0, len(progress))
+	for agency := range progress {
+		agencies = append(agencies, int(agency))
+	}
+	sort.Ints(agencies)
+	var buf bytes.Buffer
+	for _, agency := range agencies {
+		fmt.Fprintf(&buf, "%d,%d\n", agency, progress[uint8(agency)])
+	}
+
+	path := progressPath(s.config.Path)
+	file, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if s.config.Sync == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if s.config.Sync == SyncAlways {
+		return syncDir(filepath.Dir(path))
+	}
+	return nil
+}
+
+// progressPath Location of the sidecar file of the CSV at path
+func progressPath(path string) string {
+	return path + ".seq"
+}
+
+// readProgress Parses the sidecar file, made of one agency,seq line per
+// agency. A missing file means no upload was stored yet
+func readProgress(path string) (map[uint8]uint32, error) {
+	progress := make(map[uint8]uint32)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected agency,seq, got %q", path, i+1, line)
+		}
+		agency, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid agency: %w", path, i+1, err)
+		}
+		seq, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid sequence number: %w", path, i+1, err)
+		}
+		progress[uint8(agency)] = uint32(seq)
+	}
+	return progress, nil
+}
+
+// writeRow Writes a CSV row the way Python's csv.writer does with
+// QUOTE_MINIMAL: fields are quoted only if they contain the delimiter,
+// the quote character or a line break, and rows end in \r\n
+func writeRow(buf *bytes.Buffer, fields ...string) {
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if strings.ContainsAny(field, ",\"\r\n") {
+			buf.WriteByte('"')
+			buf.WriteString(strings.ReplaceAll(field, `"`, `""`))
+			buf.WriteByte('"')
+		} else {
+			buf.WriteString(field)
+		}
+	}
+	buf.WriteString("\r\n")
+}
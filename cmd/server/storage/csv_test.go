@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/bettest"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestCSVStoreUsesPythonLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	store, err := OpenCSVStore(CSVConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bets := []protocol.Bet{
+		bettest.New(1, "Santiago Lionel", "30904465", 7574),
+		bettest.New(2, `Juan "Pepe", Jr`, "12", 1),
+	}
+	if err := store.Append(bets[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append(bets[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "1,Santiago Lionel,Lorca,30904465,1999-03-17,7574\r\n" +
+		"2,\"Juan \"\"Pepe\"\", Jr\",Lorca,12,1999-03-17,1\r\n"
+	if string(data) != expected {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+
+	loaded := scanAll(t, store, nil)
+	if len(loaded) != len(bets) {
+		t.Fatalf("expected %d bets, got %d", len(bets), len(loaded))
+	}
+	for i := range bets {
+		if loaded[i] != bets[i] {
+			t.Errorf("expected %+v, got %+v", bets[i], loaded[i])
+		}
+	}
+}
+
+func TestCSVStoreScanOfMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	store, err := OpenCSVStore(CSVConfig{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	os.Remove(path)
+
+	if bets := scanAll(t, store, nil); len(bets) != 0 {
+		t.Fatalf("no bet was expected, got %+v", bets)
+	}
+}
+
+func TestCSVStoreFlushesBySyncPolicy(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncInterval, SyncNever} {
+		t.Run(string(policy), func(t *testing.T) {
+			store, err := OpenCSVStore(CSVConfig{
+				Path:         filepath.Join(t.TempDir(), "bets.csv"),
+				Sync:         policy,
+				SyncInterval: 10 * time.Millisecond,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Append([]protocol.Bet{bettest.New(1, "Ana", "1", 1)}); err != nil {
+				t.Fatal(err)
+			}
+
+			dirty := func() bool {
+				store.mu.RLock()
+				defer store.mu.RUnlock()
+				return store.dirty
+			}
+			switch policy {
+			case SyncAlways:
+				if dirty() {
+					t.Fatal("expected the batch to be flushed by Append")
+				}
+			case SyncInterval:
+				deadline := time.Now().Add(time.Second)
+				for dirty() && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				if dirty() {
+					t.Fatal("expected the batch to be flushed within the interval")
+				}
+			case SyncNever:
+				time.Sleep(30 * time.Millisecond)
+				if !dirty() {
+					t.Fatal("expected the batch to be left to the operating system")
+				}
+			}
+
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if dirty() {
+				t.Fatal("expected the batches to be flushed on close")
+			}
+		})
+	}
+}
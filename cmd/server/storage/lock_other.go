@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package storage
+
+import "os"
+
+// Advisory locks are not available, so stores are only safe within a
+// single process
+
+func lockFile(f *os.File, exclusive bool) error { return nil }
+
+func tryLockFile(f *os.File) error { return nil }
+
+func unlockFile(f *os.File) error { return nil }
@@ -0,0 +1,501 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const (
+	// DefaultSegmentBytes Size a segment may grow to before a new one is
+	// started
+	DefaultSegmentBytes = 64 << 20
+
+	// recordHeaderSize Payload length (uint32) followed by its CRC-32C
+	// (uint32)
+	recordHeaderSize = 8
+	// batchHeaderSize Agency (uint8) and sequence number (uint32) of the
+	// batch, at the start of the payload of its record
+	batchHeaderSize = 5
+	segmentExt      = ".seg"
+	lockFileName    = "LOCK"
+)
+
+var (
+	// ErrCorrupt A record of the log does not match its checksum
+	ErrCorrupt = errors.New("corrupt record")
+	// ErrLocked The log is in use by another process
+	ErrLocked = errors.New("store is locked by another process")
+	// ErrClosed The store was already closed
+	ErrClosed = errors.New("store is closed")
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// LogConfig Configuration of a LogStore
+type LogConfig struct {
+	// Dir Directory of the segments of the log
+	Dir string
+	// SegmentBytes Size a segment may grow to before a new one is started
+	SegmentBytes int64
+	Sync         SyncPolicy
+	// SyncInterval Period of the flushes of the interval sync policy
+	SyncInterval time.Duration
+}
+
+// withDefaults Replaces zero values by their defaults
+func (c LogConfig) withDefaults() LogConfig {
+	if c.SegmentBytes <= 0 {
+		c.SegmentBytes = DefaultSegmentBytes
+	}
+	if c.Sync == "" {
+		c.Sync = SyncInterval
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = DefaultSyncInterval
+	}
+	return c
+}
+
+// segment A file of the log, numbered in creation order
+type segment struct {
+	path string
+	size int64
+}
+
+// LogStore Append-only binary log of bets, split in segment files. Each
+// batch is stored as a single record holding its agency and sequence
+// number followed by the bets encoded as in the protocol, preceded by its
+// length and CRC-32C checksum, so a batch torn by a crash is detected and
+// dropped as a whole when the log is opened again, and the progress of an
+// upload is never stored apart from its bets.
+// Appends are serialized by a mutex, while scans read a snapshot of the
+// log and do not block them. The log is owned by a single process, which
+// holds an advisory lock on it while open
+type LogStore struct {
+	config LogConfig
+	lock   *os.File
+
+	mu       sync.RWMutex
+	segments []segment
+	active   *os.File
+	count    int
+	progress map[uint8]uint32
+	dirty    bool
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenLogStore Opens the log in the configured directory, creating it if
+// it does not exist. Records of the last segment that were not completely
+// written are truncated, while corrupt records anywhere else are reported
+// with ErrCorrupt
+func OpenLogStore(config LogConfig) (*LogStore, error) {
+	config = config.withDefaults()
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(filepath.Join(config.Dir, lockFileName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("could not open %s: %w", config.Dir, err)
+	}
+
+	s := &LogStore{config: config, lock: lock, progress: make(map[uint8]uint32)}
+	if err := s.recover(); err != nil {
+		s.releaseLock()
+		return nil, err
+	}
+	if config.Sync == SyncInterval {
+		s.stop, s.done = make(chan struct{}), make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// recover Loads the segments of the log, checking every record, and opens
+// the last one for appending
+func (s *LogStore) recover() error {
+	paths, err := filepath.Glob(filepath.Join(s.config.Dir, "*"+segmentExt))
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for i, path := range paths {
+		valid, count, err := checkSegment(path, s.progress)
+		if err != nil {
+			return err
+		}
+		s.count += count
+		s.segments = append(s.segments, segment{path: path, size: valid.size})
+		if !valid.torn {
+			continue
+		}
+		if i != len(paths)-1 {
+			return fmt.Errorf("%s:%d: %w", path, valid.size, ErrCorrupt)
+		}
+		logs.Warning("recover_store", logs.Success,
+			logs.Any("segment", path),
+			logs.Any("offset", valid.size),
+			logs.Err(errors.New("dropping incomplete records at the end of the log")),
+		)
+		if err := os.Truncate(path, valid.size); err != nil {
+			return err
+		}
+	}
+
+	if len(s.segments) == 0 {
+		return s.startSegment()
+	}
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// validPrefix Part of a segment made of complete, valid records
+type validPrefix struct {
+	size int64
+	// torn Whether the segment has bytes after the valid prefix
+	torn bool
+}
+
+// checkSegment Reads every record of the segment, returning the valid
+// prefix and the bets it holds. The progress of the uploads is updated
+// with the batches of the valid prefix
+func checkSegment(path string, progress map[uint8]uint32) (validPrefix, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return validPrefix{}, 0, err
+	}
+	defer file.Close()
+
+	var valid validPrefix
+	count := 0
+	reader := bufio.NewReader(file)
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			return valid, count, nil
+		}
+		var b batch
+		if err == nil {
+			b, err = decodeBatch(payload)
+		}
+		if errors.Is(err, ErrCorrupt) || errors.Is(err, io.ErrUnexpectedEOF) {
+			valid.torn = true
+			return valid, count, nil
+		}
+		if err != nil {
+			return validPrefix{}, 0, fmt.Errorf("%s: %w", path, err)
+		}
+		valid.size += int64(recordHeaderSize + len(payload))
+		count += len(b.bets)
+		if b.seq > 0 {
+			progress[b.agency] = b.seq
+		}
+	}
+}
+
+// startSegment Creates the next segment and makes it the active one
+func (s *LogStore) startSegment() error {
+	path := filepath.Join(s.config.Dir, fmt.Sprintf("%08d%s", len(s.segments)+1, segmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	// Flush the directory entry, so the segment is not lost once records
+	// are flushed into it
+	if err := syncDir(s.config.Dir); err != nil {
+		file.Close()
+		return err
+	}
+	s.active = file
+	s.segments = append(s.segments, segment{path: path})
+	return nil
+}
+
+// Append Writes the batch as a single record, with no sequence number
+func (s *LogStore) Append(bets []protocol.Bet) error {
+	return s.append(batch{bets: bets})
+}
+
+// AppendBatch Writes the batch as a single record holding seq, so the
+// progress of agency is stored along with its bets
+func (s *LogStore) AppendBatch(agency uint8, seq uint32, bets []protocol.Bet) error {
+	return s.append(batch{agency: agency, seq: seq, bets: bets})
+}
+
+// Progress Progress of the uploads, as found in the records of the log
+func (s *LogStore) Progress() (map[uint8]uint32, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	progress := make(map[uint8]uint32, len(s.progress))
+	for agency, seq := range s.progress {
+		progress[agency] = seq
+	}
+	return progress, nil
+}
+
+// append Writes the batch as a single record. If the active segment is
+// full, a new one is started first
+func (s *LogStore) append(b batch) error {
+	if len(b.bets) == 0 {
+		return nil
+	}
+	record, err := encodeRecord(b)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+
+	last := &s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > s.config.SegmentBytes {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		if err := s.startSegment(); err != nil {
+			return err
+		}
+		last = &s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		// Drop whatever part of the record was written, so the next
+		// records are not appended after a torn one
+		s.active.Truncate(last.size)
+		return err
+	}
+	if s.config.Sync == SyncAlways {
+		if err := s.active.Sync(); err != nil {
+			return err
+		}
+	} else {
+		s.dirty = true
+	}
+	last.size += int64(len(record))
+	s.count += len(b.bets)
+	if b.seq > 0 {
+		s.progress[b.agency] = b.seq
+	}
+	return nil
+}
+
+// Scan Reads the records written before the scan started. Records
+// appended in the meantime are not handed out
+func (s *LogStore) Scan(filter Filter, fn func(protocol.Bet) error) error {
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return ErrClosed
+	}
+	segments := append([]segment(nil), s.segments...)
+	s.mu.RUnlock()
+
+	for _, seg := range segments {
+		if err := scanSegment(seg, filter, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanSegment(seg segment, filter Filter, fn func(protocol.Bet) error) error {
+	file, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(io.LimitReader(file, seg.size))
+	for {
+		payload, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", seg.path, err)
+		}
+		b, err := decodeBatch(payload)
+		if err != nil {
+			return fmt.Errorf("%s: %w", seg.path, err)
+		}
+		for _, bet := range b.bets {
+			if filter != nil && !filter(bet) {
+				continue
+			}
+			if err := fn(bet); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Count Bets stored in the log
+func (s *LogStore) Count() (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	return s.count, nil
+}
+
+// Close Flushes the log to disk and releases it
+func (s *LogStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	err := s.active.Sync()
+	if closeErr := s.active.Close(); err == nil {
+		err = closeErr
+	}
+	s.releaseLock()
+	return err
+}
+
+// syncLoop Flushes the appended batches every SyncInterval until the log
+// is closed
+func (s *LogStore) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.config.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if s.dirty {
+			if err := s.active.Sync(); err != nil {
+				logs.Error("sync_store", logs.Fail, logs.Any("dir", s.config.Dir), logs.Err(err))
+			} else {
+				s.dirty = false
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *LogStore) releaseLock() {
+	unlockFile(s.lock)
+	s.lock.Close()
+}
+
+// batch Bets stored by a single append. A zero seq means the bets are not
+// part of an upload
+type batch struct {
+	agency uint8
+	seq    uint32
+	bets   []protocol.Bet
+}
+
+// encodeRecord Encodes the batch as a record: payload length, payload
+// CRC-32C, agency, sequence number and the bets encoded one after the
+// other
+func encodeRecord(b batch) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, recordHeaderSize))
+	buf.WriteByte(b.agency)
+	binary.Write(&buf, binary.BigEndian, b.seq)
+	for _, bet := range b.bets {
+		if err := protocol.EncodeBet(&buf, bet); err != nil {
+			return nil, err
+		}
+	}
+	record := buf.Bytes()
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(payload, crcTable))
+	return record, nil
+}
+
+// readRecord Reads the payload of the next record, checking it against
+// its checksum. io.EOF is returned only at the end of the last record
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length == 0 || length > protocol.MaxPayloadSize {
+		return nil, fmt.Errorf("%w: invalid length %d", ErrCorrupt, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return payload, nil
+}
+
+// decodeBatch Decodes the batch of a record payload
+func decodeBatch(payload []byte) (batch, error) {
+	if len(payload) < batchHeaderSize {
+		return batch{}, fmt.Errorf("%w: payload of %d bytes", ErrCorrupt, len(payload))
+	}
+	b := batch{agency: payload[0], seq: binary.BigEndian.Uint32(payload[1:batchHeaderSize])}
+	r := bytes.NewReader(payload[batchHeaderSize:])
+	for r.Len() > 0 {
+		bet, err := protocol.DecodeBet(r)
+		if err != nil {
+			return batch{}, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		b.bets = append(b.bets, bet)
+	}
+	return b, nil
+}
+
+// syncDir Flushes the entries of the directory to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/bettest"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func openTestLog(t *testing.T, config LogConfig) *LogStore {
+	t.Helper()
+	store, err := OpenLogStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestLogStoreRollsSegmentsAndReopens(t *testing.T) {
+	config := LogConfig{Dir: t.TempDir(), SegmentBytes: 64, Sync: SyncNever}
+	store := openTestLog(t, config)
+	for i := 1; i <= 5; i++ {
+		if err := store.Append([]protocol.Bet{bettest.New(1, "Ana", strconv.Itoa(i), 1), bettest.New(2, "Juan", strconv.Itoa(i), 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, _ := filepath.Glob(filepath.Join(config.Dir, "*"+segmentExt))
+	if len(segments) < 2 {
+		t.Fatalf("expected the log to be split in segments, got %v", segments)
+	}
+	store = openTestLog(t, config)
+	if count, err := store.Count(); err != nil || count != 10 {
+		t.Fatalf("expected 10 bets after reopening, got %d, %v", count, err)
+	}
+	if bets := scanAll(t, store, ByAgency(2)); len(bets) != 5 || bets[4].Document != "5" {
+		t.Fatalf("expected the 5 bets of agency 2, got %+v", bets)
+	}
+}
+
+func TestLogStoreDropsTornRecordsOnOpen(t *testing.T) {
+	config := LogConfig{Dir: t.TempDir(), Sync: SyncAlways}
+	store := openTestLog(t, config)
+	if err := store.Append([]protocol.Bet{bettest.New(1, "Ana", "1", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Append([]protocol.Bet{bettest.New(1, "Juan", "2", 1), bettest.New(1, "Eva", "3", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	// Simulate a crash in the middle of the second batch
+	path := filepath.Join(config.Dir, "00000001"+segmentExt)
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	store = openTestLog(t, config)
+	if bets := scanAll(t, store, nil); len(bets) != 1 || bets[0].Document != "1" {
+		t.Fatalf("expected only the first batch, got %+v", bets)
+	}
+	if err := store.Append([]protocol.Bet{bettest.New(1, "Luis", "4", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := store.Count(); err != nil || count != 2 || len(scanAll(t, store, nil)) != 2 {
+		t.Fatalf("expected 2 bets, got %d, %v", count, err)
+	}
+}
+
+func TestLogStoreReportsCorruptSegments(t *testing.T) {
+	config := LogConfig{Dir: t.TempDir(), SegmentBytes: 16, Sync: SyncNever}
+	store := openTestLog(t, config)
+	for i := 1; i <= 2; i++ {
+		if err := store.Append([]protocol.Bet{bettest.New(1, "Ana", strconv.Itoa(i), 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	// Flip a byte of the first segment, which is no longer being written
+	path := filepath.Join(config.Dir, "00000001"+segmentExt)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xFF
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenLogStore(config); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected a corrupt log, got %v", err)
+	}
+}
+
+func TestLogStoreIsLockedWhileOpen(t *testing.T) {
+	config := LogConfig{Dir: t.TempDir()}
+	store := openTestLog(t, config)
+
+	if _, err := OpenLogStore(config); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected the log to be locked, got %v", err)
+	}
+	store.Close()
+	openTestLog(t, config)
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Filter Selects the bets a scan hands out. A nil filter selects every bet
+type Filter func(protocol.Bet) bool
+
+// ByAgency Selects the bets of the given agency
+func ByAgency(agency uint8) Filter {
+	return func(bet protocol.Bet) bool { return bet.Agency == agency }
+}
+
+// BetStore Persistent collection of bets. Implementations must be safe for
+// concurrent use
+type BetStore interface {
+	// Append Stores a batch of bets. Batches are stored as a whole or not
+	// at all
+	Append(bets []protocol.Bet) error
+	// AppendBatch Stores a batch of bets uploaded by agency along with seq,
+	// its sequence number, which becomes the progress of the agency
+	AppendBatch(agency uint8, seq uint32, bets []protocol.Bet) error
+	// Progress Sequence number of the last batch stored per agency by
+	// AppendBatch. Bets stored by Append are not part of any upload, so
+	// they are not counted
+	Progress() (map[uint8]uint32, error)
+	// Scan Calls fn for each stored bet selected by filter, in storage
+	// order. The scan stops at the first error returned by fn
+	Scan(filter Filter, fn func(protocol.Bet) error) error
+	// Count Amount of stored bets
+	Count() (int, error)
+	// Close Releases the resources of the store
+	Close() error
+}
+
+// Backend Kind of storage used by a store
+type Backend string
+
+const (
+	// BackendCSV CSV file compatible with the Python server
+	BackendCSV Backend = "csv"
+	// BackendLog Checksummed append-only binary segment log
+	BackendLog Backend = "log"
+)
+
+const (
+	// DefaultPath Location of the file of the CSV backend
+	DefaultPath = "./bets.csv"
+	// DefaultLogDir Location of the segments of the log backend
+	DefaultLogDir = "./bets"
+	// DefaultSyncInterval Period of the flushes of the interval sync policy
+	DefaultSyncInterval = time.Second
+)
+
+// SyncPolicy When appended batches are flushed to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways Flushes every batch before Append returns. A batch
+	// acknowledged to a client survives a machine crash
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval Flushes every SyncInterval. A machine crash loses the
+	// batches of the last interval at most
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever Leaves flushing to the operating system
+	SyncNever SyncPolicy = "never"
+)
+
+// ParseSyncPolicy Validates a sync policy read from the configuration
+func ParseSyncPolicy(policy string) (SyncPolicy, error) {
+	switch SyncPolicy(policy) {
+	case SyncAlways, SyncInterval, SyncNever:
+		return SyncPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("invalid sync policy %q: must be %q, %q or %q", policy, SyncAlways, SyncInterval, SyncNever)
+	}
+}
+
+// Config Configuration of the store opened by Open
+type Config struct {
+	Backend Backend
+	// Path CSV file, or directory of the segments of the log. Empty
+	// selects the default location of the backend
+	Path string
+	// Sync When appended batches are flushed to disk
+	Sync SyncPolicy
+	// SyncInterval Period of the flushes of the interval sync policy
+	SyncInterval time.Duration
+}
+
+// ParseBackend Validates a backend read from the configuration
+func ParseBackend(backend string) (Backend, error) {
+	switch Backend(backend) {
+	case BackendCSV, BackendLog:
+		return Backend(backend), nil
+	default:
+		return "", fmt.Errorf("invalid storage backend %q: must be %q or %q", backend, BackendCSV, BackendLog)
+	}
+}
+
+// DefaultLocation Path the backend stores the bets at when none is
+// configured
+func (b Backend) DefaultLocation() string {
+	if b == BackendLog {
+		return DefaultLogDir
+	}
+	return DefaultPath
+}
+
+// Open Opens the store of the configured backend
+func Open(config Config) (BetStore, error) {
+	if config.Path == "" {
+		config.Path = config.Backend.DefaultLocation()
+	}
+	switch config.Backend {
+	case BackendCSV, "":
+		return OpenCSVStore(CSVConfig{Path: config.Path, Sync: config.Sync, SyncInterval: config.SyncInterval})
+	case BackendLog:
+		return OpenLogStore(LogConfig{Dir: config.Path, Sync: config.Sync, SyncInterval: config.SyncInterval})
+	default:
+		return nil, fmt.Errorf("invalid storage backend %q", config.Backend)
+	}
+}
@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/cmd/server/bettest"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func scanAll(t *testing.T, store BetStore, filter Filter) []protocol.Bet {
+	t.Helper()
+	var bets []protocol.Bet
+	if err := store.Scan(filter, func(bet protocol.Bet) error {
+		bets = append(bets, bet)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return bets
+}
+
+// testStores Opens a store of every backend in a temporary directory
+func testStores(t *testing.T) map[Backend]BetStore {
+	t.Helper()
+	dir := t.TempDir()
+	stores := make(map[Backend]BetStore)
+	for _, config := range []Config{
+		{Backend: BackendCSV, Path: filepath.Join(dir, "bets.csv")},
+		{Backend: BackendLog, Path: filepath.Join(dir, "bets"), Sync: SyncAlways},
+	} {
+		store, err := Open(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		stores[config.Backend] = store
+	}
+	return stores
+}
+
+func TestStoresScanWhatWasAppended(t *testing.T) {
+	for backend, store := range testStores(t) {
+		t.Run(string(backend), func(t *testing.T) {
+			if count, err := store.Count(); err != nil || count != 0 {
+				t.Fatalf("expected an empty store, got %d, %v", count, err)
+			}
+			bets := []protocol.Bet{bettest.New(1, "Ana", "1", 10), bettest.New(2, "Juan", "2", 20), bettest.New(1, "Eva", "3", 30)}
+			if err := store.Append(bets[:2]); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Append(bets[2:]); err != nil {
+				t.Fatal(err)
+			}
+
+			if count, err := store.Count(); err != nil || count != 3 {
+				t.Fatalf("expected 3 bets, got %d, %v", count, err)
+			}
+			scanned := scanAll(t, store, nil)
+			for i := range bets {
+				if scanned[i] != bets[i] {
+					t.Errorf("expected %+v, got %+v", bets[i], scanned[i])
+				}
+			}
+			if scanned := scanAll(t, store, ByAgency(1)); len(scanned) != 2 || scanned[1].Document != "3" {
+				t.Fatalf("expected the 2 bets of agency 1, got %+v", scanned)
+			}
+		})
+	}
+}
+
+func TestStoresKeepConcurrentBatchesWhole(t *testing.T) {
+	const writers, batches, batchSize = 8, 20, 5
+	for backend, store := range testStores(t) {
+		t.Run(string(backend), func(t *testing.T) {
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(agency uint8) {
+					defer wg.Done()
+					for b := 0; b < batches; b++ {
+						bets := make([]protocol.Bet, batchSize)
+						for i := range bets {
+							bets[i] = bettest.New(agency, fmt.Sprintf("Bettor%d", b), strconv.Itoa(b*batchSize+i+1), 1)
+						}
+						if err := store.Append(bets); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}(uint8(w + 1))
+			}
+			wg.Wait()
+
+			scanned := scanAll(t, store, nil)
+			if len(scanned) != writers*batches*batchSize {
+				t.Fatalf("expected %d bets, got %d", writers*batches*batchSize, len(scanned))
+			}
+			for i := 0; i < len(scanned); i += batchSize {
+				for _, bet := range scanned[i : i+batchSize] {
+					if bet.Agency != scanned[i].Agency || bet.FirstName != scanned[i].FirstName {
+						t.Fatalf("batches were interleaved at bet %d", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBackendsHaveTheirOwnDefaultLocation(t *testing.T) {
+	if location := BackendCSV.DefaultLocation(); location != DefaultPath {
+		t.Errorf("expected the CSV backend to default to %s, got %s", DefaultPath, location)
+	}
+	if location := BackendLog.DefaultLocation(); location != DefaultLogDir {
+		t.Errorf("expected the log backend to default to %s, got %s", DefaultLogDir, location)
+	}
+}
+
+func TestStoresPersistTheProgressOfUploads(t *testing.T) {
+	dir := t.TempDir()
+	for _, config := range []Config{
+		{Backend: BackendCSV, Path: filepath.Join(dir, "bets.csv"), Sync: SyncAlways},
+		{Backend: BackendLog, Path: filepath.Join(dir, "bets"), Sync: SyncAlways},
+	} {
+		t.Run(string(config.Backend), func(t *testing.T) {
+			store, err := Open(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Bets stored outside an upload do not count as progress
+			if err := store.Append([]protocol.Bet{bettest.New(3, "Luis", "9", 1)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendBatch(1, 2, []protocol.Bet{bettest.New(1, "Ana", "1", 1), bettest.New(1, "Eva", "2", 1)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendBatch(2, 1, []protocol.Bet{bettest.New(2, "Juan", "3", 1)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendBatch(1, 3, []protocol.Bet{bettest.New(1, "Sol", "4", 1)}); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err = Open(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			progress, err := store.Progress()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(progress) != 2 || progress[1] != 3 || progress[2] != 1 {
+				t.Fatalf("expected agency 1 at 3 and agency 2 at 1, got %v", progress)
+			}
+			if count, err := store.Count(); err != nil || count != 5 {
+				t.Fatalf("expected 5 bets, got %d, %v", count, err)
+			}
+		})
+	}
+}
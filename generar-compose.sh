@@ -0,0 +1,10 @@
+#!/bin/bash
+# Generates a compose definition with the server and the given amount of
+# clients, using the generator at cmd/composegen
+if [ "$#" -ne 2 ]; then
+    echo "usage: $0 <output-file> <clients>" >&2
+    exit 2
+fi
+# The output path is resolved before moving to the module root
+output="$(cd "$(dirname "$1")" && pwd)/$(basename "$1")" || exit 1
+cd "$(dirname "$0")" && exec go run ./cmd/composegen "$output" "$2"
@@ -0,0 +1,43 @@
+// Package httpserver HTTP server shared by the client and the server to
+// expose their metrics and health endpoints
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/logs"
+)
+
+// shutdownTimeout Time allowed for in-flight requests once the process is
+// shutting down
+const shutdownTimeout = time.Second
+
+// Start Serves handler on address until ctx is cancelled. The listener is
+// opened before returning, so an address already in use is reported as an
+// error instead of being logged in the background. The address actually
+// listened on is returned, which differs from address if its port is 0
+func Start(ctx context.Context, address string, handler http.Handler) (net.Addr, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logs.Error("http_server", logs.Fail, logs.Err(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logs.Info("http_server", logs.Success, logs.Any("address", listener.Addr()))
+	return listener.Addr(), nil
+}
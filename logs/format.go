@@ -0,0 +1,93 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+const (
+	// FormatText Lines in the `action: X | result: Y` format
+	FormatText = "text"
+	// FormatJSON One JSON object per line
+	FormatJSON = "json"
+)
+
+// textFormat Format of the text log lines. Black box tests rely on it, so
+// it must not change
+const textFormat = `%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`
+
+// logField Key and value of a log message field
+type logField struct {
+	key   string
+	value string
+}
+
+// jsonFormatter Formats every record as a JSON object. The fields of lines
+// logged through LogAction become members of the object; anything else is
+// kept under the message member
+type jsonFormatter struct{}
+
+// NewFormatter Formatter of the given log format, either FormatText or
+// FormatJSON
+func NewFormatter(format string) (logging.Formatter, error) {
+	switch format {
+	case FormatText:
+		return logging.NewStringFormatter(textFormat)
+	case FormatJSON:
+		return jsonFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func (jsonFormatter) Format(calldepth int, r *logging.Record, w io.Writer) error {
+	fields := []logField{
+		{key: "time", value: r.Time.Format(time.RFC3339)},
+		{key: "level", value: r.Level.String()},
+	}
+	if e, ok := recordEntry(r); ok {
+		fields = append(fields, e.logFields()...)
+	} else {
+		fields = append(fields, logField{key: "message", value: r.Message()})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeJSONString(&buf, field.key)
+		buf.WriteByte(':')
+		writeJSONString(&buf, field.value)
+	}
+	buf.WriteByte('}')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// recordEntry Entry logged through LogAction the record holds, if any
+func recordEntry(r *logging.Record) (entry, bool) {
+	if len(r.Args) != 1 {
+		return entry{}, false
+	}
+	e, ok := r.Args[0].(entry)
+	return e, ok
+}
+
+// writeJSONString Writes s as a JSON string without escaping HTML
+// characters
+func writeJSONString(buf *bytes.Buffer, s string) {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	// Encoding a string never fails
+	_ = encoder.Encode(s)
+	// Encode terminates the value with a newline
+	buf.Truncate(buf.Len() - 1)
+}
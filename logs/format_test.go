@@ -0,0 +1,103 @@
+package logs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/op/go-logging"
+)
+
+func newTestLogger(t *testing.T, format string) (*logging.Logger, *bytes.Buffer) {
+	t.Helper()
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	backend := logging.AddModuleLevel(logging.NewBackendFormatter(logging.NewLogBackend(&buf, "", 0), formatter))
+	logger := logging.MustGetLogger("test")
+	logger.SetBackend(backend)
+	return logger, &buf
+}
+
+func decodeJSONLine(t *testing.T, buf *bytes.Buffer) map[string]string {
+	t.Helper()
+	if !strings.HasSuffix(buf.String(), "}\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("expected a single JSON line, got %q", buf.String())
+	}
+	var record map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("invalid JSON line %q: %v", buf.String(), err)
+	}
+	return record
+}
+
+func TestJSONLogFormatHasFields(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Init(&buf, "INFO", FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	Error("connect", Fail, Any("client_id", "3"), Err(errors.New("dial tcp: <nil> refused")))
+
+	record := decodeJSONLine(t, &buf)
+	expected := map[string]string{
+		"level":     "ERROR",
+		"action":    "connect",
+		"result":    "fail",
+		"client_id": "3",
+		"error":     "dial tcp: <nil> refused",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, record[key])
+		}
+	}
+}
+
+func TestJSONLogFormatKeepsFieldsWithSeparators(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Init(&buf, "INFO", FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	Error("apuesta_enviada", Fail, Any("client_id", "1"), Err(errors.New("server said: a | b: c")))
+
+	record := decodeJSONLine(t, &buf)
+	expected := map[string]string{
+		"action":    "apuesta_enviada",
+		"result":    "fail",
+		"client_id": "1",
+		"error":     "server said: a | b: c",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, record[key])
+		}
+	}
+	if _, ok := record["message"]; ok {
+		t.Errorf("expected no message member, got %q", record["message"])
+	}
+}
+
+func TestJSONLogFormatKeepsFreeMessages(t *testing.T) {
+	logger, buf := newTestLogger(t, FormatJSON)
+	logger.Error("invalid configuration:\n  - 'id' is required")
+
+	record := decodeJSONLine(t, buf)
+	if record["message"] != "invalid configuration:\n  - 'id' is required" || record["action"] != "" {
+		t.Fatalf("expected a single message member, got %v", record)
+	}
+}
+
+func TestTextLogFormatIsUnchanged(t *testing.T) {
+	logger, buf := newTestLogger(t, FormatText)
+	logger.Infof("action: apuesta_enviada | result: success | client_id: %v | cantidad: %v", 1, 100)
+
+	line := buf.String()
+	suffix := " INFO     action: apuesta_enviada | result: success | client_id: 1 | cantidad: 100\n"
+	if len(line) != len("2006-01-02 15:04:05")+len(suffix) || !strings.HasSuffix(line, suffix) {
+		t.Fatalf("unexpected text line %q", line)
+	}
+}
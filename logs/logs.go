@@ -0,0 +1,185 @@
+// Package logs Logging facade shared by the client and the server. Log
+// lines follow the `action: X | result: Y | key: value` convention, which
+// black box tests rely on, so they are only built through LogAction and
+// its level helpers
+package logs
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/op/go-logging"
+)
+
+// Level Severity of a log line
+type Level = logging.Level
+
+// Levels supported, from the most to the least severe
+const (
+	CRITICAL = logging.CRITICAL
+	ERROR    = logging.ERROR
+	WARNING  = logging.WARNING
+	NOTICE   = logging.NOTICE
+	INFO     = logging.INFO
+	DEBUG    = logging.DEBUG
+)
+
+// Result Outcome of the action being logged
+type Result string
+
+const (
+	Success    Result = "success"
+	Fail       Result = "fail"
+	Retry      Result = "retry"
+	InProgress Result = "in_progress"
+	Ignored    Result = "ignored"
+	// Interrupted Action aborted by a graceful shutdown
+	Interrupted Result = "interrupted"
+)
+
+// Field Key and value appended to a log line after the result
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Any Field with an arbitrary value, formatted with %v
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err Field holding the error that made the action fail
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// entry Line logged by LogAction
+type entry struct {
+	action string
+	result Result
+	fields []Field
+}
+
+// logFields Action, result and fields of the entry, in that order, with
+// their values formatted with %v
+func (e entry) logFields() []logField {
+	fields := make([]logField, 0, len(e.fields)+2)
+	fields = append(fields,
+		logField{key: "action", value: e.action},
+		logField{key: "result", value: string(e.result)},
+	)
+	for _, field := range e.fields {
+		fields = append(fields, logField{key: field.Key, value: fmt.Sprint(field.Value)})
+	}
+	return fields
+}
+
+// String Entry in the `action: X | result: Y | key: value` format
+func (e entry) String() string {
+	var line strings.Builder
+	for i, field := range e.logFields() {
+		if i > 0 {
+			line.WriteString(" | ")
+		}
+		fmt.Fprintf(&line, "%s: %s", field.key, field.value)
+	}
+	return line.String()
+}
+
+// backend Single go-logging logger every line goes through
+var backend = logging.MustGetLogger("log")
+
+// currentLevel Level of the lines logged, as an int32 so it can be changed
+// while other goroutines log. go-logging keeps module levels in a map
+// without locking, so its backend is left at DEBUG after Init and lines
+// are filtered here instead
+var currentLevel = int32(INFO)
+
+// Init Sets the level and the format of the log lines written to w. If
+// either of them is not valid an error is returned
+func Init(w io.Writer, level string, format string) error {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		return err
+	}
+	levelCode, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	leveled := logging.AddModuleLevel(logging.NewBackendFormatter(logging.NewLogBackend(w, "", 0), formatter))
+	leveled.SetLevel(DEBUG, "")
+	logging.SetBackend(leveled)
+	SetLevel(levelCode)
+	return nil
+}
+
+// ParseLevel Parses one of CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG
+func ParseLevel(level string) (Level, error) {
+	return logging.LogLevel(level)
+}
+
+// SetLevel Changes the level of the log lines written from now on. It is
+// safe to call while other goroutines log
+func SetLevel(level Level) {
+	atomic.StoreInt32(&currentLevel, int32(level))
+}
+
+// IsEnabledFor Whether lines of the given level are logged
+func IsEnabledFor(level Level) bool {
+	// Levels are ordered from the most to the least severe
+	return int32(level) <= atomic.LoadInt32(&currentLevel)
+}
+
+// LogAction Logs the result of an action along with the given fields
+func LogAction(level Level, action string, result Result, fields ...Field) {
+	if !IsEnabledFor(level) {
+		return
+	}
+
+	// The entry is the only argument of the record so formatters get the
+	// typed fields instead of the rendered line
+	message := entry{action: action, result: result, fields: fields}
+
+	switch level {
+	case CRITICAL:
+		backend.Critical(message)
+	case ERROR:
+		backend.Error(message)
+	case WARNING:
+		backend.Warning(message)
+	case NOTICE:
+		backend.Notice(message)
+	case INFO:
+		backend.Info(message)
+	default:
+		backend.Debug(message)
+	}
+}
+
+// Critical Logs the result of an action with the CRITICAL level
+func Critical(action string, result Result, fields ...Field) {
+	LogAction(CRITICAL, action, result, fields...)
+}
+
+// Error Logs the result of an action with the ERROR level
+func Error(action string, result Result, fields ...Field) {
+	LogAction(ERROR, action, result, fields...)
+}
+
+// Warning Logs the result of an action with the WARNING level
+func Warning(action string, result Result, fields ...Field) {
+	LogAction(WARNING, action, result, fields...)
+}
+
+// Info Logs the result of an action with the INFO level
+func Info(action string, result Result, fields ...Field) {
+	LogAction(INFO, action, result, fields...)
+}
+
+// Debug Logs the result of an action with the DEBUG level
+func Debug(action string, result Result, fields ...Field) {
+	LogAction(DEBUG, action, result, fields...)
+}
@@ -0,0 +1,76 @@
+package logs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogActionFollowsConvention(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Init(&buf, "INFO", FormatText); err != nil {
+		t.Fatal(err)
+	}
+
+	Info("apuesta_enviada", Success, Any("client_id", "1"), Any("cantidad", 100))
+	Warning("connect", Retry, Any("client_id", "1"), Err(errors.New("refused")))
+	Debug("consulta_ganadores", InProgress, Any("client_id", "1"))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	expected := []string{
+		" INFO     action: apuesta_enviada | result: success | client_id: 1 | cantidad: 100",
+		" WARNI     action: connect | result: retry | client_id: 1 | error: refused",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %d lines, got %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		if !strings.HasSuffix(line, expected[i]) {
+			t.Errorf("expected line ending in %q, got %q", expected[i], line)
+		}
+	}
+}
+
+func TestInitRejectsInvalidSettings(t *testing.T) {
+	if err := Init(&bytes.Buffer{}, "LOUD", FormatText); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+	if err := Init(&bytes.Buffer{}, "INFO", "xml"); err == nil {
+		t.Error("expected an error for an invalid format")
+	}
+}
+
+func TestSetLevelWhileLogging(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Init(&buf, "INFO", FormatText); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if i%2 == 0 {
+				SetLevel(DEBUG)
+			} else {
+				SetLevel(WARNING)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		Info("apuesta_enviada", Success, Any("client_id", "1"))
+	}
+	<-done
+
+	buf.Reset()
+	Info("apuesta_enviada", Success, Any("client_id", "1"))
+	if buf.Len() != 0 {
+		t.Fatalf("expected INFO lines to be filtered at WARNING, got %q", buf.String())
+	}
+	SetLevel(INFO)
+	Info("apuesta_enviada", Success, Any("client_id", "1"))
+	if !strings.Contains(buf.String(), "action: apuesta_enviada | result: success") {
+		t.Fatalf("expected INFO lines to be logged at INFO, got %q", buf.String())
+	}
+}
@@ -0,0 +1,224 @@
+package logs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotateConfig Rotation policy of a log file
+type RotateConfig struct {
+	// Path File the log lines are written to
+	Path string
+	// MaxBytes Size the file may reach before being rotated
+	MaxBytes int64
+	// MaxFiles Amount of rotated files retained, older ones are removed
+	MaxFiles int
+	// Compress Gzips the rotated files
+	Compress bool
+}
+
+// RotatingFile Log file that is rotated once it reaches its maximum size.
+// The current file keeps the configured path while rotated ones are
+// renamed to path.1, path.2 and so on, path.1 being the newest. Rotated
+// files are compressed in the background, so writes are not held up by
+// them. It is safe for concurrent use
+type RotatingFile struct {
+	config RotateConfig
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	// compressed Receives the result of the compression of the last
+	// rotated file, nil if none was started since it was last awaited
+	compressed chan error
+}
+
+// OpenRotatingFile Opens the log file for appending, creating it and its
+// directory if needed
+func OpenRotatingFile(config RotateConfig) (*RotatingFile, error) {
+	if config.MaxBytes <= 0 {
+		return nil, fmt.Errorf("invalid log file size %d", config.MaxBytes)
+	}
+	if err := os.MkdirAll(filepath.Dir(config.Path), 0o755); err != nil {
+		return nil, err
+	}
+	file, size, err := openLogFile(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &RotatingFile{config: config, file: file, size: size}, nil
+}
+
+// Write Appends p to the file, rotating it first if p does not fit. Lines
+// are never split between files, so a line bigger than the maximum size
+// is written to a file of its own. If the rotation fails p is still
+// appended to the current file, the rotation being retried on the next
+// write, and the rotation error is returned
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if f.size > 0 && f.size+int64(len(p)) > f.config.MaxBytes {
+		if err := f.rotate(); err != nil {
+			rotateErr = fmt.Errorf("could not rotate log file: %w", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// Close Closes the current file once the compression of the last rotated
+// one finishes
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	compressErr := f.waitCompression()
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return err
+	}
+	return compressErr
+}
+
+// openLogFile Opens the file at path for appending, along with its size
+func openLogFile(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// rotate Shifts the retained files by one, dropping the oldest, moves the
+// current file to path.1 and opens a new one. The current file is only
+// replaced once the new one is open, so it is kept if the rotation fails
+func (f *RotatingFile) rotate() error {
+	// The previous rotated file must be in place before shifting it
+	if err := f.waitCompression(); err != nil {
+		return err
+	}
+
+	if f.config.MaxFiles <= 0 {
+		if err := f.file.Truncate(0); err != nil {
+			return err
+		}
+		f.size = 0
+		return nil
+	}
+
+	if err := removeIfExists(f.rotatedName(f.config.MaxFiles)); err != nil {
+		return err
+	}
+	for i := f.config.MaxFiles - 1; i >= 1; i-- {
+		if err := renameIfExists(f.rotatedName(i), f.rotatedName(i+1)); err != nil {
+			return err
+		}
+	}
+
+	rotated := fmt.Sprintf("%s.%d", f.config.Path, 1)
+	if err := os.Rename(f.config.Path, rotated); err != nil {
+		return err
+	}
+	file, size, err := openLogFile(f.config.Path)
+	if err != nil {
+		// Best effort to keep appending to the file at the configured path
+		os.Rename(rotated, f.config.Path)
+		return err
+	}
+	f.file.Close()
+	f.file = file
+	f.size = size
+
+	if f.config.Compress {
+		f.compressed = make(chan error, 1)
+		go func(done chan<- error) {
+			done <- compressFile(rotated, f.rotatedName(1))
+		}(f.compressed)
+	}
+	return nil
+}
+
+// waitCompression Waits for the compression of the last rotated file, if
+// any, returning its error
+func (f *RotatingFile) waitCompression() error {
+	if f.compressed == nil {
+		return nil
+	}
+	err := <-f.compressed
+	f.compressed = nil
+	if err != nil {
+		return fmt.Errorf("could not compress rotated log file: %w", err)
+	}
+	return nil
+}
+
+// rotatedName Name of the i-th newest rotated file
+func (f *RotatingFile) rotatedName(i int) string {
+	name := fmt.Sprintf("%s.%d", f.config.Path, i)
+	if f.config.Compress {
+		name += ".gz"
+	}
+	return name
+}
+
+// compressFile Gzips src into dst and removes src
+func compressFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+func removeIfExists(name string) error {
+	if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func renameIfExists(from string, to string) error {
+	if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package logs
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeLines(t *testing.T, f *RotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := f.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileKeepsMaxFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "client.log")
+	f, err := OpenRotatingFile(RotateConfig{Path: path, MaxBytes: 10, MaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Every line fills a file, so each write rotates the previous one
+	writeLines(t, f, "line-0001", "line-0002", "line-0003", "line-0004")
+
+	expected := map[string]string{
+		path:        "line-0004\n",
+		path + ".1": "line-0003\n",
+		path + ".2": "line-0002\n",
+	}
+	for name, content := range expected {
+		if got := readFile(t, name); got != content {
+			t.Errorf("%s: expected %q, got %q", name, content, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept, got %v", err)
+	}
+}
+
+func TestRotatingFileCompressesRotatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.log")
+	f, err := OpenRotatingFile(RotateConfig{Path: path, MaxBytes: 20, MaxFiles: 1, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	writeLines(t, f, "line-0001", "line-0002", "line-0003")
+	// Rotated files are compressed in the background, closing waits for it
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(path + ".1.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "line-0001\nline-0002\n" || readFile(t, path) != "line-0003\n" {
+		t.Fatalf("unexpected contents: rotated %q, current %q", data, readFile(t, path))
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected the uncompressed rotated file to be removed, got %v", err)
+	}
+}
+
+func TestRotatingFileKeepsWritingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.log")
+	f, err := OpenRotatingFile(RotateConfig{Path: path, MaxBytes: 10, MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// A non empty directory in place of the oldest rotated file cannot be
+	// removed, so the rotation fails
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, f, "line-0001")
+	if _, err := f.Write([]byte("line-0002\n")); err == nil {
+		t.Fatal("expected the rotation to fail")
+	}
+	if got := readFile(t, path); got != "line-0001\nline-0002\n" {
+		t.Fatalf("expected the lines to be kept in the current file, got %q", got)
+	}
+
+	// The rotation is retried on the next write
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, f, "line-0003")
+	if got := readFile(t, path+".1"); got != "line-0001\nline-0002\n" {
+		t.Errorf("expected the previous lines to be rotated, got %q", got)
+	}
+	if got := readFile(t, path); got != "line-0003\n" {
+		t.Errorf("expected the current file to hold the last line, got %q", got)
+	}
+}
+
+func TestRotatingFileAppendsToExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.log")
+	if err := os.WriteFile(path, []byte("previous\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenRotatingFile(RotateConfig{Path: path, MaxBytes: 12, MaxFiles: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	writeLines(t, f, "next")
+	if got := readFile(t, path+".1"); !strings.HasPrefix(got, "previous") {
+		t.Fatalf("expected the existing content to count towards the size, got %q", got)
+	}
+}
@@ -0,0 +1,300 @@
+// Package metrics Counters and histograms exposed in the Prometheus text
+// exposition format, without depending on the Prometheus client library
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets Upper bounds, in seconds, of the latency histograms
+var DefaultBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector Metric family that writes its samples in the text format
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry Set of metrics exposed together. It is safe for concurrent use
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry Initializes an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write Writes every metric of the registry in the Prometheus text format
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	return buf.Flush()
+}
+
+// ServeHTTP Serves the metrics of the registry to a Prometheus scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// Counter Monotonically increasing value
+type Counter struct {
+	// value First field so it is 64-bit aligned for atomic operations
+	value uint64
+}
+
+// Inc Increments the counter by one
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add Increments the counter by n
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value Current value of the counter
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// Gauge Value that can go up and down
+type Gauge struct {
+	// value First field so it is 64-bit aligned for atomic operations
+	value int64
+	name  string
+	help  string
+}
+
+// NewGauge Registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	g := &Gauge{name: name, help: help}
+	r.register(g)
+	return g
+}
+
+// Inc Increments the gauge by one
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+// Dec Decrements the gauge by one
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+// Set Replaces the value of the gauge
+func (g *Gauge) Set(value int64) {
+	atomic.StoreInt64(&g.value, value)
+}
+
+// Value Current value of the gauge
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %d\n", g.name, g.Value())
+}
+
+// CounterVec Counters of a metric partitioned by the value of a label
+type CounterVec struct {
+	name     string
+	help     string
+	label    string
+	mu       sync.Mutex
+	counters map[string]*Counter
+}
+
+// NewCounter Registers a counter without labels
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help, "").WithLabel("")
+}
+
+// NewCounterVec Registers a counter partitioned by the given label
+func (r *Registry) NewCounterVec(name, help, label string) *CounterVec {
+	v := &CounterVec{name: name, help: help, label: label, counters: make(map[string]*Counter)}
+	r.register(v)
+	return v
+}
+
+// WithLabel Counter of the given label value, created on first use
+func (v *CounterVec) WithLabel(value string) *Counter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	c, ok := v.counters[value]
+	if !ok {
+		c = &Counter{}
+		v.counters[value] = c
+	}
+	return c
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	values := make([]string, 0, len(v.counters))
+	for value := range v.counters {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, labels(v.label, value, "", ""), v.counters[value].Value())
+	}
+}
+
+// Histogram Distribution of observed values over a set of buckets
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	// counts Observations per bucket, the last one being +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Observe Adds a value to the histogram
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
+
+// ObserveDuration Adds a duration to the histogram, in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Since Adds the time elapsed since start to the histogram, in seconds
+func (h *Histogram) Since(start time.Time) {
+	h.ObserveDuration(time.Since(start))
+}
+
+// Count Amount of values observed
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// HistogramVec Histograms of a metric partitioned by the value of a label
+type HistogramVec struct {
+	name       string
+	help       string
+	label      string
+	buckets    []float64
+	mu         sync.Mutex
+	histograms map[string]*Histogram
+}
+
+// NewHistogram Registers a histogram without labels. Buckets must be
+// sorted in increasing order
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	return r.NewHistogramVec(name, help, "", buckets).WithLabel("")
+}
+
+// NewHistogramVec Registers a histogram partitioned by the given label.
+// Buckets must be sorted in increasing order
+func (r *Registry) NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	v := &HistogramVec{name: name, help: help, label: label, buckets: buckets, histograms: make(map[string]*Histogram)}
+	r.register(v)
+	return v
+}
+
+// WithLabel Histogram of the given label value, created on first use
+func (v *HistogramVec) WithLabel(value string) *Histogram {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.histograms[value]
+	if !ok {
+		h = &Histogram{buckets: v.buckets, counts: make([]uint64, len(v.buckets)+1)}
+		v.histograms[value] = h
+	}
+	return h
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	values := make([]string, 0, len(v.histograms))
+	for value := range v.histograms {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		h := v.histograms[value]
+		h.mu.Lock()
+		cumulative := uint64(0)
+		for i, count := range h.counts {
+			cumulative += count
+			bound := math.Inf(1)
+			if i < len(h.buckets) {
+				bound = h.buckets[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, labels(v.label, value, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, labels(v.label, value, "", ""), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, labels(v.label, value, "", ""), h.count)
+		h.mu.Unlock()
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labels Label set of a sample, omitting labels without a name
+func labels(name, value, extraName, extraValue string) string {
+	var pairs []string
+	if name != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(value)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, escapeLabel(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestRegistryExposesTextFormat(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("bets_total", "Bets sent.").Add(3)
+	sent := r.NewCounterVec("messages_total", "Messages sent.", "type")
+	sent.WithLabel("BET").Inc()
+	sent.WithLabel("BET_START").Inc()
+	sent.WithLabel("BET").Inc()
+	connections := r.NewGauge("connections", "Open connections.")
+	connections.Inc()
+	connections.Inc()
+	connections.Dec()
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.1)
+	latency.ObserveDuration(2 * time.Second)
+
+	expected := `# HELP bets_total Bets sent.
+# TYPE bets_total counter
+bets_total 3
+# HELP messages_total Messages sent.
+# TYPE messages_total counter
+messages_total{type="BET"} 2
+messages_total{type="BET_START"} 1
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 2.15
+latency_seconds_count 3
+`
+	if body := scrape(t, r); body != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, body)
+	}
+}
+
+func TestLabelValuesAreEscaped(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("errors_total", "Errors.", "error").WithLabel("a \"quoted\"\nerror").Inc()
+
+	if body := scrape(t, r); !strings.Contains(body, `errors_total{error="a \"quoted\"\nerror"} 1`) {
+		t.Fatalf("label value was not escaped:\n%s", body)
+	}
+}
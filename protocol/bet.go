@@ -0,0 +1,202 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DateLayout ISO 8601 layout used for bet birthdates
+	DateLayout = "2006-01-02"
+	// MaxBetNumber Highest number a bet can be placed on
+	MaxBetNumber = 9999
+	// maxNameLength Names are sent prefixed by a single byte length
+	maxNameLength = 255
+	// minBetSize Size of an encoded bet with empty names
+	minBetSize = 1 + 1 + 1 + 4 + 4 + 2
+	// MaxBetSize Size of an encoded bet with the longest names allowed
+	MaxBetSize = minBetSize + 2*maxNameLength
+)
+
+// Bet A lottery bet registry placed by a person in an agency
+type Bet struct {
+	Agency    uint8
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate time.Time
+	Number    uint16
+}
+
+// NewBet Parses and validates the fields of a bet, following the same
+// formats accepted by the server: agency and number must be integers,
+// document must be numeric and birthdate must be formatted as 'YYYY-MM-DD'.
+// The document is kept as a string, as the server stores it, so leading
+// zeros are not lost
+func NewBet(agency, firstName, lastName, document, birthdate, number string) (Bet, error) {
+	agencyID, err := strconv.ParseUint(agency, 10, 8)
+	if err != nil {
+		return Bet{}, fmt.Errorf("invalid agency %q: must be an integer between 0 and 255", agency)
+	}
+	date, err := time.Parse(DateLayout, birthdate)
+	if err != nil {
+		return Bet{}, fmt.Errorf("invalid birthdate %q: must be formatted as YYYY-MM-DD", birthdate)
+	}
+	num, err := strconv.ParseUint(number, 10, 16)
+	if err != nil {
+		return Bet{}, fmt.Errorf("invalid number %q: must be an integer between 0 and %d", number, MaxBetNumber)
+	}
+
+	bet := Bet{
+		Agency:    uint8(agencyID),
+		FirstName: firstName,
+		LastName:  lastName,
+		Document:  document,
+		Birthdate: date,
+		Number:    uint16(num),
+	}
+	if err := bet.Validate(); err != nil {
+		return Bet{}, err
+	}
+	return bet, nil
+}
+
+// Validate Checks the bet fields are within the ranges the protocol and
+// the server accept
+func (b Bet) Validate() error {
+	if err := validateName("first name", b.FirstName); err != nil {
+		return err
+	}
+	if err := validateName("last name", b.LastName); err != nil {
+		return err
+	}
+	if err := validateDocument(b.Document); err != nil {
+		return err
+	}
+	if b.Birthdate.IsZero() || b.Birthdate.After(time.Now()) {
+		return fmt.Errorf("invalid birthdate %v: must be a past date", b.Birthdate.Format(DateLayout))
+	}
+	if b.Number > MaxBetNumber {
+		return fmt.Errorf("invalid number %d: must be between 0 and %d", b.Number, MaxBetNumber)
+	}
+	return nil
+}
+
+func validateName(field, name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("invalid %s: must not be empty", field)
+	}
+	if len(name) > maxNameLength {
+		return fmt.Errorf("invalid %s: must not exceed %d bytes", field, maxNameLength)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("invalid %s: must be valid UTF-8", field)
+	}
+	return nil
+}
+
+func validateDocument(document string) error {
+	if len(document) == 0 || len(document) > maxNameLength {
+		return fmt.Errorf("invalid document %q: must have between 1 and %d digits", document, maxNameLength)
+	}
+	for _, c := range document {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("invalid document %q: must be numeric", document)
+		}
+	}
+	return nil
+}
+
+// EncodeBet Writes the binary representation of the bet into buf. Fields
+// follow the order of the server Bet: agency, first name, last name,
+// document, birthdate and number
+//
+//	1 byte:  agency (uint8)
+//	1 byte:  first name length + first name (UTF-8)
+//	1 byte:  last name length + last name (UTF-8)
+//	1 byte:  document length + document (ASCII digits)
+//	4 bytes: birthdate (uint32, YYYYMMDD)
+//	2 bytes: number (uint16)
+func EncodeBet(buf *bytes.Buffer, b Bet) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	buf.WriteByte(b.Agency)
+	if err := writeString(buf, b.FirstName); err != nil {
+		return err
+	}
+	if err := writeString(buf, b.LastName); err != nil {
+		return err
+	}
+	if err := writeString(buf, b.Document); err != nil {
+		return err
+	}
+	_ = binary.Write(buf, binary.BigEndian, dateToUint32(b.Birthdate))
+	_ = binary.Write(buf, binary.BigEndian, b.Number)
+	return nil
+}
+
+// EncodedBetSize Bytes taken by the bet once encoded with EncodeBet
+func EncodedBetSize(b Bet) int {
+	return minBetSize + len(b.FirstName) + len(b.LastName)
+}
+
+// DecodeBet Reads a bet previously written with EncodeBet from r. Bets
+// that are well formed but not valid are reported with ErrInvalidBet
+func DecodeBet(r io.Reader) (Bet, error) {
+	var b Bet
+	var date uint32
+
+	if err := binary.Read(r, binary.BigEndian, &b.Agency); err != nil {
+		return Bet{}, fmt.Errorf("could not read agency: %w", err)
+	}
+	firstName, err := readString(r)
+	if err != nil {
+		return Bet{}, fmt.Errorf("could not read first name: %w", err)
+	}
+	lastName, err := readString(r)
+	if err != nil {
+		return Bet{}, fmt.Errorf("could not read last name: %w", err)
+	}
+	document, err := readString(r)
+	if err != nil {
+		return Bet{}, fmt.Errorf("could not read document: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &date); err != nil {
+		return Bet{}, fmt.Errorf("could not read birthdate: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &b.Number); err != nil {
+		return Bet{}, fmt.Errorf("could not read number: %w", err)
+	}
+
+	b.FirstName = firstName
+	b.LastName = lastName
+	b.Document = document
+	b.Birthdate = uint32ToDate(date)
+	if err := b.Validate(); err != nil {
+		return Bet{}, fmt.Errorf("%w: %v", ErrInvalidBet, err)
+	}
+	return b, nil
+}
+
+// dateToUint32 Packs a date as the decimal number YYYYMMDD
+func dateToUint32(t time.Time) uint32 {
+	return uint32(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+// uint32ToDate Unpacks a date packed with dateToUint32. Out of range
+// values produce a zero time, which is rejected by Validate
+func uint32ToDate(v uint32) time.Time {
+	year, month, day := int(v/10000), time.Month(v/100%100), int(v%100)
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if date.Year() != year || date.Month() != month || date.Day() != day {
+		return time.Time{}
+	}
+	return date
+}
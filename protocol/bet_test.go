@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewBetMustKeepFields(t *testing.T) {
+	b, err := NewBet("1", "Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Agency != 1 || b.FirstName != "Santiago Lionel" || b.LastName != "Lorca" ||
+		b.Document != "30904465" || b.Birthdate.Format(DateLayout) != "1999-03-17" || b.Number != 7574 {
+		t.Fatalf("fields were not kept: %+v", b)
+	}
+}
+
+func TestNewBetRejectsInvalidFields(t *testing.T) {
+	cases := map[string][]string{
+		"non numeric document": {"1", "first", "last", "30.904.465", "1999-03-17", "7574"},
+		"empty document":       {"1", "first", "last", "", "1999-03-17", "7574"},
+		"non ISO birthdate":    {"1", "first", "last", "30904465", "17/03/1999", "7574"},
+		"number out of range":  {"1", "first", "last", "30904465", "1999-03-17", "10000"},
+		"negative number":      {"1", "first", "last", "30904465", "1999-03-17", "-1"},
+		"empty first name":     {"1", "", "last", "30904465", "1999-03-17", "7574"},
+	}
+	for name, f := range cases {
+		if _, err := NewBet(f[0], f[1], f[2], f[3], f[4], f[5]); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEncodeAndDecodeBetKeepsDocumentDigits(t *testing.T) {
+	for _, document := range []string{"00904465", "123456789012"} {
+		sent, err := NewBet("1", "first", "last", document, "1999-03-17", "7574")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := EncodeBet(&buf, sent); err != nil {
+			t.Fatalf("unexpected encode error: %v", err)
+		}
+		received, err := DecodeBet(&buf)
+		if err != nil {
+			t.Fatalf("unexpected decode error: %v", err)
+		}
+		if received.Document != document {
+			t.Errorf("expected document %q, got %q", document, received.Document)
+		}
+	}
+}
+
+func TestEncodeAndDecodeBetKeepsFields(t *testing.T) {
+	sent, err := NewBet("3", "Tiago Nicolás", "Ñañez", "34407251", "2001-08-29", "1033")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeBet(&buf, sent); err != nil {
+		t.Fatalf("unexpected encode error: %v", err)
+	}
+	received, err := DecodeBet(&buf)
+	if err != nil {
+		t.Fatalf("unexpected decode error: %v", err)
+	}
+	if !received.Birthdate.Equal(sent.Birthdate) {
+		t.Fatalf("expected birthdate %v, got %v", sent.Birthdate, received.Birthdate)
+	}
+	received.Birthdate = sent.Birthdate
+	if received != sent {
+		t.Fatalf("expected %+v, got %+v", sent, received)
+	}
+}
+
+func TestDecodeBetReportsInvalidBets(t *testing.T) {
+	var buf bytes.Buffer
+	bet := Bet{Agency: 1, FirstName: "Ana", LastName: "Lorca", Document: "1", Birthdate: time.Date(1999, 3, 17, 0, 0, 0, 0, time.UTC), Number: 1}
+	if err := EncodeBet(&buf, bet); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	data[len(data)-2], data[len(data)-1] = 0xFF, 0xFF
+
+	if _, err := DecodeBet(bytes.NewReader(data)); !errors.Is(err, ErrInvalidBet) {
+		t.Fatalf("expected ErrInvalidBet, got %v", err)
+	}
+}
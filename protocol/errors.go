@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode Reason sent by the server in an ErrorPacket
+type ErrorCode uint8
+
+const (
+	// CodeInvalidPacket The server could not parse the packet or did not
+	// expect it
+	CodeInvalidPacket ErrorCode = 0x01
+	// CodeInvalidBet The server rejected the contents of a bet
+	CodeInvalidBet ErrorCode = 0x02
+	// CodeSessionMismatch The packet belongs to an agency other than the
+	// one that started the session, or no session was started
+	CodeSessionMismatch ErrorCode = 0x03
+	// CodeLotteryNotDone Winners were queried before the lottery draw
+	CodeLotteryNotDone ErrorCode = 0x04
+	// CodeInternal The server could not process a valid packet, such as
+	// when bets cannot be stored
+	CodeInternal ErrorCode = 0x05
+	// CodeOutOfSequence The batch would leave a gap in the bets stored for
+	// the agency
+	CodeOutOfSequence ErrorCode = 0x06
+)
+
+var (
+	// ErrInvalidPacket Returned for error packets with CodeInvalidPacket
+	ErrInvalidPacket = errors.New("invalid packet")
+	// ErrInvalidBet Returned for error packets with CodeInvalidBet
+	ErrInvalidBet = errors.New("invalid bet")
+	// ErrSessionMismatch Returned for error packets with CodeSessionMismatch
+	ErrSessionMismatch = errors.New("session mismatch")
+	// ErrLotteryNotDone Returned for error packets with CodeLotteryNotDone
+	ErrLotteryNotDone = errors.New("lottery not done")
+	// ErrInternal Returned for error packets with CodeInternal
+	ErrInternal = errors.New("internal server error")
+	// ErrOutOfSequence Returned for error packets with CodeOutOfSequence
+	ErrOutOfSequence = errors.New("out of sequence")
+	// ErrUnknownCode Returned for error packets with an unknown code
+	ErrUnknownCode = errors.New("unknown error code")
+)
+
+// Err Go error value the code maps to, to be checked with errors.Is
+func (c ErrorCode) Err() error {
+	switch c {
+	case CodeInvalidPacket:
+		return ErrInvalidPacket
+	case CodeInvalidBet:
+		return ErrInvalidBet
+	case CodeSessionMismatch:
+		return ErrSessionMismatch
+	case CodeLotteryNotDone:
+		return ErrLotteryNotDone
+	case CodeInternal:
+		return ErrInternal
+	case CodeOutOfSequence:
+		return ErrOutOfSequence
+	default:
+		return ErrUnknownCode
+	}
+}
+
+// String Human readable name of the code, used in logs and errors
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeInvalidPacket:
+		return "INVALID_PACKET"
+	case CodeInvalidBet:
+		return "INVALID_BET"
+	case CodeSessionMismatch:
+		return "SESSION_MISMATCH"
+	case CodeLotteryNotDone:
+		return "LOTTERY_NOT_DONE"
+	case CodeInternal:
+		return "INTERNAL"
+	case CodeOutOfSequence:
+		return "OUT_OF_SEQUENCE"
+	default:
+		return fmt.Sprintf("UNKNOWN(0x%02x)", uint8(c))
+	}
+}
+
+// Error Allows error packets to be returned as Go errors
+func (p *ErrorPacket) Error() string {
+	return fmt.Sprintf("server error %v: %s", p.Code, p.Message)
+}
+
+// Unwrap Exposes the error value of the code, so callers can check error
+// packets with errors.Is(err, ErrInvalidBet) and the like
+func (p *ErrorPacket) Unwrap() error {
+	return p.Code.Err()
+}
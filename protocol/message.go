@@ -0,0 +1,43 @@
+package protocol
+
+// MessageType Identifies the kind of payload carried by a frame
+type MessageType uint8
+
+const (
+	// MsgBet Batch of bets sent by an agency to be stored
+	MsgBet MessageType = 0x01
+	// MsgReply Successful answer from the server
+	MsgReply MessageType = 0x02
+	// MsgError Error answer from the server
+	MsgError MessageType = 0x03
+	// MsgBetStart Start of the bet upload session of an agency
+	MsgBetStart MessageType = 0x04
+	// MsgBetFinish End of the bet upload session of an agency
+	MsgBetFinish MessageType = 0x05
+	// MsgGetWinners Query for the winners of an agency
+	MsgGetWinners MessageType = 0x06
+	// MsgReplyWinners Documents of the winners of an agency
+	MsgReplyWinners MessageType = 0x07
+)
+
+// String Human readable name of the message type, used in logs and errors
+func (t MessageType) String() string {
+	switch t {
+	case MsgBet:
+		return "BET"
+	case MsgReply:
+		return "REPLY"
+	case MsgError:
+		return "ERROR"
+	case MsgBetStart:
+		return "BET_START"
+	case MsgBetFinish:
+		return "BET_FINISH"
+	case MsgGetWinners:
+		return "GET_WINNERS"
+	case MsgReplyWinners:
+		return "REPLY_WINNERS"
+	default:
+		return "UNKNOWN"
+	}
+}
@@ -0,0 +1,315 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// BetPacketHeaderSize Bytes used by a BetPacket before its bets
+const BetPacketHeaderSize = 8
+
+// Packet Message of the lottery protocol. Packets are serialized as the
+// payload of a Frame whose type is given by Type
+type Packet interface {
+	Type() MessageType
+	encode(buf *bytes.Buffer) error
+}
+
+// BetStartPacket Starts the bet upload session of an agency. The server
+// answers with a ReplyPacket whose count is the amount of bets of the
+// agency it already stored, so an interrupted upload can be resumed
+//
+//	1 byte: agency id (uint8)
+type BetStartPacket struct {
+	AgencyID uint8
+}
+
+// BetPacket Batch of bets sent by an agency
+//
+//	4 bytes: sequence number (uint32)
+//	4 bytes: bet count (uint32)
+//	N bytes: bets (see EncodeBet)
+type BetPacket struct {
+	// Seq Sequence number of the batch within the bets of the agency: the
+	// amount of bets sent up to and including this batch. It grows with
+	// every batch and does not depend on the batch limits, so a batch sent
+	// again after a lost reply can be recognized by the server
+	Seq  uint32
+	Bets []Bet
+}
+
+// BetFinishPacket Finishes the bet upload session of an agency
+//
+//	1 byte: agency id (uint8)
+type BetFinishPacket struct {
+	AgencyID uint8
+}
+
+// GetWinnersPacket Query for the winners of an agency
+//
+//	1 byte: agency id (uint8)
+type GetWinnersPacket struct {
+	AgencyID uint8
+}
+
+// ReplyWinnersPacket Documents of the winners of an agency
+//
+//	1 byte:  agency id (uint8)
+//	4 bytes: winner count (uint32)
+//	N times: document length (1 byte) + winner document (ASCII digits)
+type ReplyWinnersPacket struct {
+	AgencyID uint8
+	Winners  []string
+}
+
+// ReplyPacket Successful answer from the server
+//
+//	4 bytes: done count (uint32)
+//	1 byte:  message length + message (UTF-8)
+type ReplyPacket struct {
+	Count   uint32
+	Message string
+}
+
+// ErrorPacket Error answer from the server
+//
+//	1 byte: error code (uint8)
+//	1 byte: message length + message (UTF-8)
+type ErrorPacket struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (p *BetStartPacket) Type() MessageType     { return MsgBetStart }
+func (p *BetPacket) Type() MessageType          { return MsgBet }
+func (p *BetFinishPacket) Type() MessageType    { return MsgBetFinish }
+func (p *GetWinnersPacket) Type() MessageType   { return MsgGetWinners }
+func (p *ReplyWinnersPacket) Type() MessageType { return MsgReplyWinners }
+func (p *ReplyPacket) Type() MessageType        { return MsgReply }
+func (p *ErrorPacket) Type() MessageType        { return MsgError }
+
+func (p *BetStartPacket) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(p.AgencyID)
+	return nil
+}
+
+func (p *BetPacket) encode(buf *bytes.Buffer) error {
+	if p.Seq < uint32(len(p.Bets)) {
+		return fmt.Errorf("sequence number %d is lower than the %d bets of the batch", p.Seq, len(p.Bets))
+	}
+	_ = binary.Write(buf, binary.BigEndian, p.Seq)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(p.Bets)))
+	for i, bet := range p.Bets {
+		if err := EncodeBet(buf, bet); err != nil {
+			return fmt.Errorf("bet %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (p *BetFinishPacket) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(p.AgencyID)
+	return nil
+}
+
+func (p *GetWinnersPacket) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(p.AgencyID)
+	return nil
+}
+
+func (p *ReplyWinnersPacket) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(p.AgencyID)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(p.Winners)))
+	for _, document := range p.Winners {
+		if err := writeString(buf, document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ReplyPacket) encode(buf *bytes.Buffer) error {
+	_ = binary.Write(buf, binary.BigEndian, p.Count)
+	return writeString(buf, p.Message)
+}
+
+func (p *ErrorPacket) encode(buf *bytes.Buffer) error {
+	buf.WriteByte(uint8(p.Code))
+	return writeString(buf, p.Message)
+}
+
+// BetPacketSize Size in bytes of the frame carrying a BetPacket with the
+// given bets, header included
+func BetPacketSize(bets []Bet) int {
+	size := HeaderSize + BetPacketHeaderSize
+	for _, bet := range bets {
+		size += EncodedBetSize(bet)
+	}
+	return size
+}
+
+// Encode Serializes a packet into the frame that carries it
+func Encode(p Packet) (Frame, error) {
+	var buf bytes.Buffer
+	if err := p.encode(&buf); err != nil {
+		return Frame{}, fmt.Errorf("could not encode %v packet: %w", p.Type(), err)
+	}
+	return Frame{Type: p.Type(), Payload: buf.Bytes()}, nil
+}
+
+// Decode Deserializes the packet carried by a frame. The whole payload
+// must be consumed, trailing bytes are considered an error
+func Decode(f Frame) (Packet, error) {
+	r := bytes.NewReader(f.Payload)
+
+	var packet Packet
+	var err error
+	switch f.Type {
+	case MsgBetStart:
+		packet, err = decodeBetStartPacket(r)
+	case MsgBet:
+		packet, err = decodeBetPacket(r)
+	case MsgBetFinish:
+		packet, err = decodeBetFinishPacket(r)
+	case MsgGetWinners:
+		packet, err = decodeGetWinnersPacket(r)
+	case MsgReplyWinners:
+		packet, err = decodeReplyWinnersPacket(r)
+	case MsgReply:
+		packet, err = decodeReplyPacket(r)
+	case MsgError:
+		packet, err = decodeErrorPacket(r)
+	default:
+		return nil, fmt.Errorf("unknown message type 0x%02x", uint8(f.Type))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %v packet: %w", f.Type, err)
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("could not decode %v packet: %d trailing bytes", f.Type, r.Len())
+	}
+	return packet, nil
+}
+
+// Send Encodes the packet and writes it completely to w
+func Send(w io.Writer, p Packet) error {
+	frame, err := Encode(p)
+	if err != nil {
+		return err
+	}
+	return WriteFrame(w, frame)
+}
+
+// Recv Reads the next frame from r and decodes the packet it carries
+func Recv(r io.Reader) (Packet, error) {
+	frame, err := ReadFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(frame)
+}
+
+func decodeBetStartPacket(r io.Reader) (*BetStartPacket, error) {
+	var p BetStartPacket
+	if err := binary.Read(r, binary.BigEndian, &p.AgencyID); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func decodeBetFinishPacket(r io.Reader) (*BetFinishPacket, error) {
+	var p BetFinishPacket
+	if err := binary.Read(r, binary.BigEndian, &p.AgencyID); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func decodeBetPacket(r *bytes.Reader) (*BetPacket, error) {
+	var seq, count uint32
+	if err := binary.Read(r, binary.BigEndian, &seq); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	if seq < count {
+		return nil, fmt.Errorf("sequence number %d is lower than the %d bets of the batch", seq, count)
+	}
+	// Every bet takes at least minBetSize bytes, so a count that cannot fit
+	// in the remaining payload is rejected before allocating the batch
+	if int64(count)*minBetSize > int64(r.Len()) {
+		return nil, fmt.Errorf("announced %d bets do not fit in %d bytes", count, r.Len())
+	}
+
+	bets := make([]Bet, 0, count)
+	for i := uint32(0); i < count; i++ {
+		bet, err := DecodeBet(r)
+		if err != nil {
+			return nil, fmt.Errorf("bet %d: %w", i, err)
+		}
+		bets = append(bets, bet)
+	}
+	return &BetPacket{Seq: seq, Bets: bets}, nil
+}
+
+func decodeGetWinnersPacket(r io.Reader) (*GetWinnersPacket, error) {
+	var p GetWinnersPacket
+	if err := binary.Read(r, binary.BigEndian, &p.AgencyID); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func decodeReplyWinnersPacket(r *bytes.Reader) (*ReplyWinnersPacket, error) {
+	var p ReplyWinnersPacket
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &p.AgencyID); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	// Every document takes at least its length byte
+	if int64(count) > int64(r.Len()) {
+		return nil, fmt.Errorf("announced %d winners do not fit in %d bytes", count, r.Len())
+	}
+	p.Winners = make([]string, count)
+	for i := range p.Winners {
+		document, err := readString(r)
+		if err != nil {
+			return nil, fmt.Errorf("could not read winner %d: %w", i, err)
+		}
+		p.Winners[i] = document
+	}
+	return &p, nil
+}
+
+func decodeReplyPacket(r io.Reader) (*ReplyPacket, error) {
+	var p ReplyPacket
+	if err := binary.Read(r, binary.BigEndian, &p.Count); err != nil {
+		return nil, err
+	}
+	msg, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = msg
+	return &p, nil
+}
+
+func decodeErrorPacket(r io.Reader) (*ErrorPacket, error) {
+	var p ErrorPacket
+	if err := binary.Read(r, binary.BigEndian, &p.Code); err != nil {
+		return nil, err
+	}
+	msg, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = msg
+	return &p, nil
+}
@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	bet, err := NewBet("1", "Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	packets := []Packet{
+		&BetStartPacket{AgencyID: 1},
+		&BetPacket{Seq: 7, Bets: []Bet{bet, bet}},
+		&BetFinishPacket{AgencyID: 1},
+		&GetWinnersPacket{AgencyID: 1},
+		&ReplyWinnersPacket{AgencyID: 1, Winners: []string{"30904465", "01689196"}},
+		&ReplyWinnersPacket{AgencyID: 2},
+		&ReplyPacket{Count: 1, Message: "STORED"},
+		&ErrorPacket{Code: CodeInvalidBet, Message: "bad bet"},
+	}
+	for _, sent := range packets {
+		var buf bytes.Buffer
+		if err := Send(&buf, sent); err != nil {
+			t.Fatalf("unexpected send error: %v", err)
+		}
+		received, err := Recv(&buf)
+		if err != nil {
+			t.Fatalf("unexpected recv error: %v", err)
+		}
+		if received.Type() != sent.Type() {
+			t.Fatalf("expected %v packet, got %v", sent.Type(), received.Type())
+		}
+		if bets, ok := received.(*BetPacket); ok && (len(bets.Bets) != 2 || bets.Seq != 7) {
+			t.Fatalf("expected 2 bets with sequence number 7, got %d with %d", len(bets.Bets), bets.Seq)
+		}
+		if winners, ok := received.(*ReplyWinnersPacket); ok && fmt.Sprint(winners.Winners) != fmt.Sprint(sent.(*ReplyWinnersPacket).Winners) {
+			t.Fatalf("expected winners %v, got %v", sent.(*ReplyWinnersPacket).Winners, winners.Winners)
+		}
+	}
+}
+
+func TestErrorPacketMatchesErrorValues(t *testing.T) {
+	cases := map[ErrorCode]error{
+		CodeInvalidPacket:   ErrInvalidPacket,
+		CodeInvalidBet:      ErrInvalidBet,
+		CodeSessionMismatch: ErrSessionMismatch,
+		CodeLotteryNotDone:  ErrLotteryNotDone,
+		CodeInternal:        ErrInternal,
+		CodeOutOfSequence:   ErrOutOfSequence,
+		ErrorCode(0xFF):     ErrUnknownCode,
+	}
+	for code, expected := range cases {
+		err := fmt.Errorf("wrapped: %w", &ErrorPacket{Code: code, Message: "rejected"})
+		if !errors.Is(err, expected) {
+			t.Errorf("expected code %v to match %v", code, expected)
+		}
+	}
+}
+
+func TestDecodeRejectsTrailingBytes(t *testing.T) {
+	frame := Frame{Type: MsgBetStart, Payload: []byte{1, 2}}
+	if _, err := Decode(frame); err == nil {
+		t.Fatal("expected an error for trailing bytes")
+	}
+}
+
+func TestBetPacketSequenceCoversItsBets(t *testing.T) {
+	bet, err := NewBet("1", "Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := Encode(&BetPacket{Seq: 1, Bets: []Bet{bet, bet}}); err == nil {
+		t.Fatal("expected a sequence number lower than the bets to be rejected")
+	}
+
+	frame, err := Encode(&BetPacket{Seq: 2, Bets: []Bet{bet, bet}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frame.Payload[3] = 1
+	if _, err := Decode(frame); err == nil {
+		t.Fatal("expected a sequence number lower than the bets to be rejected")
+	}
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// HeaderSize Bytes used by the frame header: 1 byte for the message
+	// type and 4 bytes for the big-endian payload length
+	HeaderSize = 5
+	// MaxPayloadSize Upper bound for a single payload. Frames announcing a
+	// bigger payload are rejected before allocating any memory for them
+	MaxPayloadSize = 1 << 20
+)
+
+// Frame Unit of transmission between client and server. Every message is
+// sent as a fixed size header followed by a variable length payload
+type Frame struct {
+	Type    MessageType
+	Payload []byte
+}
+
+// WriteFrame Serializes the frame header and payload and writes them
+// completely to w, retrying on short writes
+func WriteFrame(w io.Writer, f Frame) error {
+	if len(f.Payload) > MaxPayloadSize {
+		return fmt.Errorf("payload of %d bytes exceeds max size of %d bytes", len(f.Payload), MaxPayloadSize)
+	}
+
+	buf := make([]byte, HeaderSize+len(f.Payload))
+	buf[0] = byte(f.Type)
+	binary.BigEndian.PutUint32(buf[1:HeaderSize], uint32(len(f.Payload)))
+	copy(buf[HeaderSize:], f.Payload)
+
+	return writeExact(w, buf)
+}
+
+// ReadFrame Reads exactly one frame from r, retrying on short reads. If the
+// stream ends in the middle of a frame io.ErrUnexpectedEOF is returned
+func ReadFrame(r io.Reader) (Frame, error) {
+	header, err := recvExact(r, HeaderSize)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > MaxPayloadSize {
+		return Frame{}, fmt.Errorf("announced payload of %d bytes exceeds max size of %d bytes", length, MaxPayloadSize)
+	}
+
+	payload, err := recvExact(r, int(length))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+
+	return Frame{Type: MessageType(header[0]), Payload: payload}, nil
+}
+
+// writeExact Writes the whole buffer to w. Writers are allowed to accept
+// less bytes than requested, so the remaining bytes are written until
+// everything was sent or an error arises
+func writeExact(w io.Writer, data []byte) error {
+	written := 0
+	for written < len(data) {
+		n, err := w.Write(data[written:])
+		written += n
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+	}
+	return nil
+}
+
+// recvExact Reads exactly nBytes from r. Readers are allowed to return less
+// bytes than requested, so reading continues until the buffer is full. An
+// io.EOF is only returned if the stream ended before reading any byte
+func recvExact(r io.Reader, nBytes int) ([]byte, error) {
+	buf := make([]byte, nBytes)
+	read := 0
+	for read < nBytes {
+		n, err := r.Read(buf[read:])
+		read += n
+		if err != nil {
+			if err == io.EOF && read > 0 && read < nBytes {
+				return nil, io.ErrUnexpectedEOF
+			}
+			if err == io.EOF && read == nBytes {
+				break
+			}
+			return nil, err
+		}
+	}
+	return buf, nil
+}
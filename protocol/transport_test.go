@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// chunkedWriter Writer that accepts at most one byte per call
+type chunkedWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return w.buf.Write(p[:1])
+}
+
+// chunkedReader Reader that returns at most one byte per call
+type chunkedReader struct {
+	r io.Reader
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.r.Read(p[:1])
+}
+
+func TestFrameRoundTripWithShortWritesAndReads(t *testing.T) {
+	sent := Frame{Type: MsgBet, Payload: []byte("Tiago Nicolás\nRivera ñandú 🎲")}
+
+	w := &chunkedWriter{}
+	if err := WriteFrame(w, sent); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	if w.buf.Len() != HeaderSize+len(sent.Payload) {
+		t.Fatalf("expected %d bytes written, got %d", HeaderSize+len(sent.Payload), w.buf.Len())
+	}
+
+	received, err := ReadFrame(&chunkedReader{r: &w.buf})
+	if err != nil {
+		t.Fatalf("unexpected read error: %v", err)
+	}
+	if received.Type != sent.Type || !bytes.Equal(received.Payload, sent.Payload) {
+		t.Fatalf("expected %v, got %v", sent, received)
+	}
+}
+
+func TestReadFrameOnTruncatedPayloadFails(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, Frame{Type: MsgBet, Payload: []byte("hello")}); err != nil {
+		t.Fatalf("unexpected write error: %v", err)
+	}
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-2])
+
+	if _, err := ReadFrame(truncated); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReadFrameOnClosedStreamReturnsEOF(t *testing.T) {
+	if _, err := ReadFrame(bytes.NewReader(nil)); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadFrameRejectsOversizedPayload(t *testing.T) {
+	header := []byte{byte(MsgBet), 0xFF, 0xFF, 0xFF, 0xFF}
+	if _, err := ReadFrame(bytes.NewReader(header)); err == nil {
+		t.Fatal("expected an error for an oversized payload")
+	}
+}
@@ -0,0 +1,30 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// writeString Writes s prefixed by its length in a single byte
+func writeString(buf *bytes.Buffer, s string) error {
+	if len(s) > 255 {
+		return fmt.Errorf("string of %d bytes does not fit in a 1 byte length prefix", len(s))
+	}
+	buf.WriteByte(uint8(len(s)))
+	buf.WriteString(s)
+	return nil
+}
+
+// readString Reads a string written with writeString
+func readString(r io.Reader) (string, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return "", err
+	}
+	data := make([]byte, length[0])
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}